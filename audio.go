@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/alaughlin/go-boi/gameboy"
+)
+
+// maxAudioLatency caps buffered audio at 100ms so a slow frame can't build up lag
+const maxAudioLatency = gameboy.SampleRate / 10 * 4
+
+// audioStream buffers samples from the console for the ebiten audio player,
+// Update pushes from the game loop and the player pulls from its own goroutine
+type audioStream struct {
+	mutex  sync.Mutex
+	buffer []byte
+}
+
+// push appends interleaved 16-bit stereo samples as little endian bytes
+func (stream *audioStream) push(samples []int16) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	for _, sample := range samples {
+		stream.buffer = append(stream.buffer, byte(sample), byte(sample>>8))
+	}
+	if over := len(stream.buffer) - maxAudioLatency; over > 0 {
+		stream.buffer = stream.buffer[:copy(stream.buffer, stream.buffer[over:])]
+	}
+}
+
+// Read hands buffered samples to the player, playing silence when the emulator falls behind
+func (stream *audioStream) Read(p []byte) (int, error) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	if len(stream.buffer) == 0 {
+		for i := range p {
+			p[i] = 0
+		}
+		return len(p), nil
+	}
+
+	n := copy(p, stream.buffer)
+	stream.buffer = stream.buffer[:copy(stream.buffer, stream.buffer[n:])]
+	return n, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alaughlin/go-boi/disasm"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("disasm: ")
+
+	symPath := flag.String("sym", "", "rgbds .sym file for labels (default: next to the rom if there is one)")
+	startFlag := flag.String("start", "", "first address to decode as bank:address or a rom offset (default: start of the rom)")
+	endFlag := flag.String("end", "", "stop before this bank:address or rom offset (default: end of the rom)")
+	showBytes := flag.Bool("bytes", true, "comment each line with its location and raw bytes")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] rom.gb\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	romPath := flag.Arg(0)
+
+	rom, err := ioutil.ReadFile(romPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	start, end := 0, len(rom)
+	if *startFlag != "" {
+		if start, err = parseLocation(*startFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *endFlag != "" {
+		if end, err = parseLocation(*endFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if start > len(rom) || start > end {
+		log.Fatalf("nothing to decode between offsets $%X and $%X of a $%X byte rom", start, end, len(rom))
+	}
+
+	symbols, err := loadSymbols(*symPath, romPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	write(w, disasm.ROM(rom, start, end), symbols, *showBytes)
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadSymbols reads path, or the .sym next to the rom when no path was given
+func loadSymbols(path string, romPath string) (*disasm.Symbols, error) {
+	if path != "" {
+		return disasm.LoadSymbols(path)
+	}
+
+	path = strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sym"
+	symbols, err := disasm.LoadSymbols(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return symbols, err
+}
+
+// parseLocation reads bank:address in hex like rgbds prints it, or a plain
+// hex rom offset
+func parseLocation(s string) (int, error) {
+	s = strings.TrimPrefix(s, "$")
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 1 {
+		offset, err := strconv.ParseUint(s, 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("bad rom offset %q", s)
+		}
+		return int(offset), nil
+	}
+
+	bank, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad bank in %q", s)
+	}
+	address, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil || address >= 0x8000 || (bank > 0 && address < disasm.BankSize) {
+		return 0, fmt.Errorf("bad address in %q, bank 0 is 0000-3FFF and the others 4000-7FFF", s)
+	}
+	return disasm.Offset(int(bank), uint16(address)), nil
+}
+
+// write prints instructions as rgbds source with a section for each bank
+func write(w *bufio.Writer, instructions []disasm.Instruction, symbols *disasm.Symbols, showBytes bool) {
+	bank := -1
+	for _, instruction := range instructions {
+		if instruction.Bank != bank {
+			bank = instruction.Bank
+			if bank == 0 {
+				fmt.Fprintf(w, "SECTION \"ROM Bank $000\", ROM0[$%04X]\n\n", instruction.Address)
+			} else {
+				fmt.Fprintf(w, "\nSECTION \"ROM Bank $%03X\", ROMX[$%04X], BANK[$%X]\n\n", bank, instruction.Address, bank)
+			}
+		}
+
+		if name, ok := symbols.Lookup(instruction.Bank, instruction.Address); ok {
+			fmt.Fprintf(w, "%s:\n", name)
+		}
+
+		text := instruction.Format(symbols)
+		if !showBytes {
+			fmt.Fprintf(w, "    %s\n", text)
+			continue
+		}
+		raw := make([]string, len(instruction.Bytes))
+		for i, n := range instruction.Bytes {
+			raw[i] = fmt.Sprintf("%02X", n)
+		}
+		fmt.Fprintf(w, "    %-24s ; %02X:%04X  %s\n", text, instruction.Bank, instruction.Address, strings.Join(raw, " "))
+	}
+}
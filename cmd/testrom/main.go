@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alaughlin/go-boi/gameboy"
+	"github.com/alaughlin/go-boi/testrom"
+)
+
+var rtcModes = map[string]gameboy.RTCMode{
+	"wall":     gameboy.RTCWallClock,
+	"emulated": gameboy.RTCEmulated,
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("testrom: ")
+
+	cycles := flag.Int("cycles", testrom.DefaultCycles, "give up on a rom after this many cycles")
+	junitPath := flag.String("junit", "", "write a JUnit XML report to this file")
+	suite := flag.String("suite", "go-boi", "test suite name used in the JUnit report")
+	rtc := flag.String("rtc", "emulated", "cartridge clock: wall follows the host's time, emulated follows emulated time")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] rom.gb|dir...\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	rtcMode, ok := rtcModes[*rtc]
+	if !ok {
+		log.Fatalf("unknown rtc mode %q, choose wall or emulated", *rtc)
+	}
+
+	var results []testrom.Result
+	for _, arg := range flag.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !info.IsDir() {
+			results = append(results, testrom.Run(arg, *cycles, rtcMode))
+			continue
+		}
+
+		dirResults, err := testrom.RunDir(arg, *cycles, rtcMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		results = append(results, dirResults...)
+	}
+
+	failed := 0
+	for _, result := range results {
+		fmt.Printf("%-7s %s (%d cycles, %v)", result.Status, result.Name, result.Cycles, result.Duration.Round(time.Millisecond))
+		if result.Message != "" {
+			fmt.Printf(": %s", result.Message)
+		}
+		fmt.Println()
+		if result.Status != testrom.Pass {
+			failed++
+		}
+	}
+	fmt.Printf("%d/%d passed\n", len(results)-failed, len(results))
+
+	if *junitPath != "" {
+		file, err := os.Create(*junitPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = testrom.WriteJUnit(file, *suite, results)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
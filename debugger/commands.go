@@ -0,0 +1,469 @@
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alaughlin/go-boi/gameboy"
+)
+
+const (
+	// listLength is how many instructions list shows from pc or an address
+	listLength = 6
+	// dumpLength is how many bytes x shows when no count is given
+	dumpLength = 0x40
+)
+
+const help = `addresses and values are hex, counts are decimal, an empty line
+repeats the last command
+  b, break [addr]       set a breakpoint at addr, with no address list them
+  d, delete [addr]      clear the breakpoint at addr, with no address clear all
+  watch addr [rwx] [v]  stop on reads, writes or execution at addr, writes by
+                        default, only when the value is v if given, with no
+                        address list them
+  unwatch [id]          clear a watchpoint, with no id clear all
+  c, continue           run until a breakpoint
+  s, step [n]           execute n instructions, 1 by default
+  n, next               like step but runs calls and rsts until they return
+  f, finish             run until the current function returns
+  stop                  pause the running console
+  r, regs               show the registers
+  set reg value         change a, f, b, c, d, e, h, l, af, bc, de, hl, sp, pc or ime
+  x addr [n]            dump n bytes of memory, 64 by default
+  w addr value...       write bytes to memory
+  l, list [addr]        disassemble around pc or from addr
+  q, quit               exit the emulator
+  h, help               show this`
+
+var errUsage = errors.New("wrong arguments, try help")
+
+// command runs one line of input
+func (debugger *Debugger) command(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		line = debugger.last
+	}
+	debugger.last = line
+
+	fields := strings.Fields(line)
+	if len(fields) > 0 {
+		err := debugger.execute(fields[0], fields[1:])
+		if errors.Is(err, ErrQuit) {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintln(debugger.out, err)
+		}
+	}
+
+	if !debugger.running {
+		fmt.Fprint(debugger.out, prompt)
+	}
+	return nil
+}
+
+func (debugger *Debugger) execute(name string, args []string) error {
+	switch name {
+	case "b", "break":
+		if len(args) == 0 {
+			debugger.listBreakpoints()
+			return nil
+		}
+		address, err := parseAddress(args)
+		if err != nil {
+			return err
+		}
+		debugger.breakpoints[address] = true
+
+	case "d", "delete":
+		if len(args) == 0 {
+			debugger.breakpoints = make(map[uint16]bool)
+			return nil
+		}
+		address, err := parseAddress(args)
+		if err != nil {
+			return err
+		}
+		if !debugger.breakpoints[address] {
+			return fmt.Errorf("no breakpoint at $%04X", address)
+		}
+		delete(debugger.breakpoints, address)
+
+	case "watch":
+		if len(args) == 0 {
+			debugger.listWatchpoints()
+			return nil
+		}
+		return debugger.addWatchpoint(args)
+
+	case "unwatch":
+		if len(args) == 0 {
+			for id := range debugger.watchpoints {
+				debugger.console.RemoveWatchpoint(id)
+			}
+			debugger.watchpoints = make(map[int]gameboy.Watchpoint)
+			return nil
+		}
+		id, err := strconv.Atoi(args[0])
+		if _, ok := debugger.watchpoints[id]; err != nil || !ok {
+			return fmt.Errorf("no watchpoint %q", args[0])
+		}
+		debugger.console.RemoveWatchpoint(id)
+		delete(debugger.watchpoints, id)
+
+	case "c", "continue":
+		debugger.resume(nil)
+
+	case "s", "step":
+		count := 1
+		if len(args) > 0 {
+			var err error
+			if count, err = parseCount(args[0]); err != nil {
+				return err
+			}
+		}
+		debugger.stepN(count)
+
+	case "n", "next":
+		registers := debugger.console.Registers()
+		info := gameboy.LookupOpcode(debugger.console.Peek(registers.PC), false)
+		if !strings.HasPrefix(info.Mnemonic, "call") && !strings.HasPrefix(info.Mnemonic, "rst") {
+			debugger.stepN(1)
+			return nil
+		}
+		// the call is done once pc is back after it with the stack unwound
+		next := registers.PC + uint16(info.Length)
+		sp := registers.SP
+		debugger.resume(func(registers gameboy.Registers) bool {
+			return registers.PC == next && registers.SP >= sp
+		})
+
+	case "f", "finish":
+		sp := debugger.console.Registers().SP
+		debugger.resume(func(registers gameboy.Registers) bool {
+			info := gameboy.LookupOpcode(debugger.lastOp, false)
+			return strings.HasPrefix(info.Mnemonic, "ret") && registers.SP > sp
+		})
+
+	case "stop":
+		if debugger.running {
+			debugger.stop("")
+		}
+
+	case "r", "regs":
+		debugger.registers()
+
+	case "set":
+		if len(args) != 2 {
+			return errUsage
+		}
+		return debugger.setRegister(strings.ToLower(args[0]), args[1])
+
+	case "x":
+		if len(args) == 0 || len(args) > 2 {
+			return errUsage
+		}
+		address, err := parseAddress(args[:1])
+		if err != nil {
+			return err
+		}
+		count := dumpLength
+		if len(args) == 2 {
+			if count, err = parseCount(args[1]); err != nil {
+				return err
+			}
+		}
+		// past the whole address space it would only wrap around
+		if count > 0x10000 {
+			count = 0x10000
+		}
+		debugger.dump(address, count)
+
+	case "w":
+		if len(args) < 2 {
+			return errUsage
+		}
+		address, err := parseAddress(args[:1])
+		if err != nil {
+			return err
+		}
+		values := make([]byte, len(args)-1)
+		for i, arg := range args[1:] {
+			n, err := parseNumber(arg, 8)
+			if err != nil {
+				return err
+			}
+			values[i] = byte(n)
+		}
+		for i, n := range values {
+			debugger.console.Poke(address+uint16(i), n)
+		}
+
+	case "l", "list":
+		if len(args) == 0 {
+			debugger.window()
+			return nil
+		}
+		address, err := parseAddress(args)
+		if err != nil {
+			return err
+		}
+		debugger.listing(address, listLength)
+
+	case "q", "quit":
+		return ErrQuit
+
+	case "h", "help":
+		fmt.Fprintln(debugger.out, help)
+
+	default:
+		return fmt.Errorf("unknown command %q, try help", name)
+	}
+	return nil
+}
+
+// stepN executes count instructions, stopping early at a breakpoint
+func (debugger *Debugger) stepN(count int) {
+	for i := 0; i < count; i++ {
+		if _, ok := debugger.step(); !ok {
+			return
+		}
+		if len(debugger.hits) > 0 {
+			debugger.stop("")
+			return
+		}
+		pc := debugger.console.Registers().PC
+		if i < count-1 && debugger.breakpoints[pc] {
+			fmt.Fprintf(debugger.out, "breakpoint at $%04X\n", pc)
+			break
+		}
+	}
+	debugger.where()
+}
+
+// where shows the registers and the instruction about to run
+func (debugger *Debugger) where() {
+	debugger.registers()
+	pc := debugger.console.Registers().PC
+	debugger.line(pc, pc)
+}
+
+func (debugger *Debugger) registers() {
+	registers := debugger.console.Registers()
+	flags := []byte("----")
+	for i, name := range "znhc" {
+		if registers.F&(0x80>>uint(i)) != 0 {
+			flags[i] = byte(name)
+		}
+	}
+	ime := 0
+	if registers.IME {
+		ime = 1
+	}
+
+	fmt.Fprintf(debugger.out, "AF:%02X%02X [%s] BC:%02X%02X DE:%02X%02X HL:%02X%02X SP:%04X PC:%04X IME:%d",
+		registers.A, registers.F, flags, registers.B, registers.C, registers.D, registers.E,
+		registers.H, registers.L, registers.SP, registers.PC, ime)
+	if registers.Halted {
+		fmt.Fprint(debugger.out, " halted")
+	}
+	fmt.Fprintln(debugger.out)
+}
+
+func (debugger *Debugger) setRegister(name string, arg string) error {
+	registers := debugger.console.Registers()
+	bytes := map[string]*byte{
+		"a": &registers.A, "f": &registers.F,
+		"b": &registers.B, "c": &registers.C,
+		"d": &registers.D, "e": &registers.E,
+		"h": &registers.H, "l": &registers.L,
+	}
+	pairs := map[string][2]*byte{
+		"af": {&registers.A, &registers.F},
+		"bc": {&registers.B, &registers.C},
+		"de": {&registers.D, &registers.E},
+		"hl": {&registers.H, &registers.L},
+	}
+
+	if r, ok := bytes[name]; ok {
+		n, err := parseNumber(arg, 8)
+		if err != nil {
+			return err
+		}
+		*r = byte(n)
+	} else if pair, ok := pairs[name]; ok {
+		n, err := parseNumber(arg, 16)
+		if err != nil {
+			return err
+		}
+		*pair[0] = byte(n >> 8)
+		*pair[1] = byte(n)
+	} else if name == "sp" || name == "pc" || name == "ime" {
+		n, err := parseNumber(arg, 16)
+		if err != nil {
+			return err
+		}
+		switch name {
+		case "sp":
+			registers.SP = uint16(n)
+		case "pc":
+			registers.PC = uint16(n)
+		default:
+			registers.IME = n != 0
+		}
+	} else {
+		return fmt.Errorf("unknown register %q", name)
+	}
+
+	debugger.console.SetRegisters(registers)
+	return nil
+}
+
+// dump prints memory 16 bytes to a row with the printable ones on the right
+func (debugger *Debugger) dump(address uint16, count int) {
+	for row := 0; row < count; row += 16 {
+		start := address + uint16(row)
+		hex := make([]string, 0, 16)
+		text := make([]byte, 0, 16)
+		for i := 0; i < 16 && row+i < count; i++ {
+			n := debugger.console.Peek(start + uint16(i))
+			hex = append(hex, fmt.Sprintf("%02X", n))
+			if n >= 0x20 && n < 0x7F {
+				text = append(text, n)
+			} else {
+				text = append(text, '.')
+			}
+		}
+		fmt.Fprintf(debugger.out, "$%04X  %-47s  %s\n", start, strings.Join(hex, " "), text)
+	}
+}
+
+func (debugger *Debugger) listBreakpoints() {
+	if len(debugger.breakpoints) == 0 {
+		fmt.Fprintln(debugger.out, "no breakpoints")
+		return
+	}
+	addresses := make([]int, 0, len(debugger.breakpoints))
+	for address := range debugger.breakpoints {
+		addresses = append(addresses, int(address))
+	}
+	sort.Ints(addresses)
+	for _, address := range addresses {
+		debugger.line(uint16(address), debugger.console.Registers().PC)
+	}
+}
+
+func (debugger *Debugger) addWatchpoint(args []string) error {
+	if len(args) > 3 {
+		return errUsage
+	}
+	address, err := parseAddress(args[:1])
+	if err != nil {
+		return err
+	}
+	watchpoint := gameboy.Watchpoint{
+		Address: address,
+		Access:  gameboy.AccessWrite,
+		Hook:    debugger.hit,
+	}
+
+	if len(args) > 1 {
+		watchpoint.Access = 0
+		for _, c := range strings.ToLower(args[1]) {
+			switch c {
+			case 'r':
+				watchpoint.Access |= gameboy.AccessRead
+			case 'w':
+				watchpoint.Access |= gameboy.AccessWrite
+			case 'x':
+				watchpoint.Access |= gameboy.AccessExecute
+			default:
+				return fmt.Errorf("access is some of r, w and x, got %q", args[1])
+			}
+		}
+	}
+	if len(args) > 2 {
+		value, err := parseNumber(args[2], 8)
+		if err != nil {
+			return err
+		}
+		watchpoint.Match = true
+		watchpoint.Value = byte(value)
+	}
+
+	id, err := debugger.console.AddWatchpoint(watchpoint)
+	if err != nil {
+		return err
+	}
+	debugger.watchpoints[id] = watchpoint
+	fmt.Fprintf(debugger.out, "watchpoint %d at $%04X\n", id, address)
+	return nil
+}
+
+func (debugger *Debugger) listWatchpoints() {
+	if len(debugger.watchpoints) == 0 {
+		fmt.Fprintln(debugger.out, "no watchpoints")
+		return
+	}
+	ids := make([]int, 0, len(debugger.watchpoints))
+	for id := range debugger.watchpoints {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		watchpoint := debugger.watchpoints[id]
+		access := []byte("---")
+		for i, c := range "rwx" {
+			if watchpoint.Access&(gameboy.AccessRead<<uint(i)) != 0 {
+				access[i] = byte(c)
+			}
+		}
+		fmt.Fprintf(debugger.out, "%d: $%04X %s", id, watchpoint.Address, access)
+		if watchpoint.Match {
+			fmt.Fprintf(debugger.out, " = $%02X", watchpoint.Value)
+		}
+		fmt.Fprintln(debugger.out)
+	}
+}
+
+// describeWatch is the line printed when a watchpoint stops the console
+func describeWatch(event gameboy.WatchEvent) string {
+	switch event.Access {
+	case gameboy.AccessWrite:
+		return fmt.Sprintf("write $%02X -> $%02X at $%04X from $%04X", event.Old, event.New, event.Address, event.PC)
+	case gameboy.AccessRead:
+		return fmt.Sprintf("read $%02X at $%04X from $%04X", event.New, event.Address, event.PC)
+	default:
+		return fmt.Sprintf("execute at $%04X", event.Address)
+	}
+}
+
+func parseAddress(args []string) (uint16, error) {
+	if len(args) != 1 {
+		return 0, errUsage
+	}
+	n, err := parseNumber(args[0], 16)
+	return uint16(n), err
+}
+
+// parseCount reads a repeat count, unlike everything else these are decimal
+func parseCount(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%q isn't a count", s)
+	}
+	return n, nil
+}
+
+// parseNumber reads hex with or without a $ or 0x in front
+func parseNumber(s string, bits int) (uint64, error) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	n, err := strconv.ParseUint(digits, 16, bits)
+	if err != nil {
+		return 0, fmt.Errorf("%q isn't a %d-bit hex number", s, bits)
+	}
+	return n, nil
+}
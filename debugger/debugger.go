@@ -0,0 +1,229 @@
+// Package debugger is a command line debugger for a running console. It
+// reads commands from a terminal on its own goroutine but only touches the
+// console from whichever goroutine calls Update or Run, so it can sit inside
+// the ebiten game loop as well as drive a headless console on its own
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/alaughlin/go-boi/gameboy"
+)
+
+const (
+	prompt = "(gb) "
+	// historySize is how many executed instructions the listing shows above pc
+	historySize = 3
+	// runCycles is how long Run lets the console go between checking for commands
+	runCycles = 69905
+)
+
+// ErrQuit is returned from Update and Run after the quit command
+var ErrQuit = errors.New("debugger quit")
+
+// Debugger stops the console at breakpoints and runs commands typed at it
+type Debugger struct {
+	console     *gameboy.Console
+	out         io.Writer
+	lines       chan string
+	interrupts  chan struct{}
+	breakpoints map[uint16]bool
+	watchpoints map[int]gameboy.Watchpoint
+	// hits collects watchpoints set off during the current instruction
+	hits    []gameboy.WatchEvent
+	running bool
+	// until is an extra stop condition checked after every instruction
+	// while running, it's set by next and finish
+	until      func(registers gameboy.Registers) bool
+	lastOp     byte
+	history    [historySize]uint16
+	historyLen int
+	last       string
+}
+
+// New returns a debugger reading commands from in and writing to out, the
+// console starts out paused
+func New(console *gameboy.Console, in io.Reader, out io.Writer) *Debugger {
+	debugger := &Debugger{
+		console:     console,
+		out:         out,
+		lines:       make(chan string),
+		interrupts:  make(chan struct{}, 1),
+		breakpoints: make(map[uint16]bool),
+		watchpoints: make(map[int]gameboy.Watchpoint),
+	}
+	go debugger.readLoop(in)
+
+	debugger.where()
+	fmt.Fprint(out, prompt)
+	return debugger
+}
+
+// readLoop hands each line typed to the emulation side
+func (debugger *Debugger) readLoop(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		debugger.lines <- scanner.Text()
+	}
+	close(debugger.lines)
+}
+
+// Interrupt pauses the console at the next instruction, it's safe to call
+// from any goroutine, e.g. a ctrl-c handler
+func (debugger *Debugger) Interrupt() {
+	select {
+	case debugger.interrupts <- struct{}{}:
+	default:
+	}
+}
+
+// Running is false while the debugger holds the console paused
+func (debugger *Debugger) Running() bool {
+	return debugger.running
+}
+
+// Update runs any commands typed since the last call, then lets the console
+// go for up to cycles if it isn't paused. It returns the cycles emulated
+func (debugger *Debugger) Update(cycles int) (int, error) {
+	if err := debugger.poll(); err != nil {
+		return 0, err
+	}
+	return debugger.run(cycles), nil
+}
+
+// Run drives the console until the quit command or the end of input
+func (debugger *Debugger) Run() error {
+	for {
+		if debugger.running {
+			if err := debugger.poll(); err != nil {
+				return err
+			}
+			debugger.run(runCycles)
+			continue
+		}
+		if debugger.lines == nil {
+			return nil
+		}
+
+		select {
+		case line, ok := <-debugger.lines:
+			if !ok {
+				return nil
+			}
+			if err := debugger.command(line); err != nil {
+				return err
+			}
+		case <-debugger.interrupts:
+		}
+	}
+}
+
+// poll runs the commands waiting without blocking
+func (debugger *Debugger) poll() error {
+	for {
+		select {
+		case line, ok := <-debugger.lines:
+			if !ok {
+				// input is gone, leave the console running on its own
+				debugger.lines = nil
+				return nil
+			}
+			if err := debugger.command(line); err != nil {
+				return err
+			}
+		case <-debugger.interrupts:
+			if debugger.running {
+				debugger.stop("interrupted")
+				fmt.Fprint(debugger.out, prompt)
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// run executes instructions for up to cycles or until something stops it
+func (debugger *Debugger) run(cycles int) int {
+	if !debugger.running {
+		return 0
+	}
+
+	total := 0
+	for debugger.running && total < cycles {
+		n, ok := debugger.step()
+		total += n
+		if !ok {
+			break
+		}
+
+		registers := debugger.console.Registers()
+		if len(debugger.hits) > 0 {
+			debugger.stop("")
+		} else if debugger.until != nil && debugger.until(registers) {
+			debugger.stop("")
+		} else if debugger.breakpoints[registers.PC] {
+			debugger.stop(fmt.Sprintf("breakpoint at $%04X", registers.PC))
+		}
+	}
+
+	if !debugger.running {
+		fmt.Fprint(debugger.out, prompt)
+	}
+	return total
+}
+
+// step executes one instruction, errors from the console pause it instead
+// of ending the session so the state can still be looked at
+func (debugger *Debugger) step() (int, bool) {
+	pc := debugger.console.Registers().PC
+	debugger.lastOp = debugger.console.Peek(pc)
+	debugger.record(pc)
+
+	n, err := debugger.console.Tick()
+	if err != nil {
+		debugger.stop(err.Error())
+		return n, false
+	}
+	return n, true
+}
+
+// hit is the hook for every watchpoint, stepping stops after the instruction
+func (debugger *Debugger) hit(event gameboy.WatchEvent) {
+	debugger.hits = append(debugger.hits, event)
+}
+
+// stop pauses the console and shows where it is
+func (debugger *Debugger) stop(reason string) {
+	debugger.running = false
+	debugger.until = nil
+	for _, event := range debugger.hits {
+		fmt.Fprintln(debugger.out, describeWatch(event))
+	}
+	debugger.hits = debugger.hits[:0]
+	if reason != "" {
+		fmt.Fprintln(debugger.out, reason)
+	}
+	debugger.where()
+}
+
+// resume lets the console run until a breakpoint or until returns true
+func (debugger *Debugger) resume(until func(registers gameboy.Registers) bool) {
+	debugger.running = true
+	debugger.until = until
+}
+
+// record remembers pc for the listing, repeats from halt or jr loops are skipped
+func (debugger *Debugger) record(pc uint16) {
+	if debugger.historyLen > 0 && debugger.history[debugger.historyLen-1] == pc {
+		return
+	}
+	if debugger.historyLen == historySize {
+		copy(debugger.history[:], debugger.history[1:])
+		debugger.historyLen--
+	}
+	debugger.history[debugger.historyLen] = pc
+	debugger.historyLen++
+}
@@ -0,0 +1,52 @@
+package debugger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alaughlin/go-boi/disasm"
+)
+
+// listing prints count instructions starting at address
+func (debugger *Debugger) listing(address uint16, count int) {
+	pc := debugger.console.Registers().PC
+	for i := 0; i < count; i++ {
+		length := debugger.line(address, pc)
+		address += uint16(length)
+	}
+}
+
+// window prints the last few instructions executed and the ones after pc
+func (debugger *Debugger) window() {
+	pc := debugger.console.Registers().PC
+	for _, address := range debugger.history[:debugger.historyLen] {
+		if address != pc {
+			debugger.line(address, pc)
+		}
+	}
+	debugger.listing(pc, listLength)
+}
+
+// line prints one instruction marking pc and breakpoints
+func (debugger *Debugger) line(address uint16, pc uint16) int {
+	var code [3]byte
+	for i := range code {
+		code[i] = debugger.console.Peek(address + uint16(i))
+	}
+	// the switched in bank isn't tracked, without symbols it doesn't matter
+	instruction := disasm.Decode(code[:], 0, address)
+	raw := make([]string, len(instruction.Bytes))
+	for i, n := range instruction.Bytes {
+		raw[i] = fmt.Sprintf("%02X", n)
+	}
+
+	mark := []byte("  ")
+	if debugger.breakpoints[address] {
+		mark[0] = '*'
+	}
+	if address == pc {
+		mark[1] = '>'
+	}
+	fmt.Fprintf(debugger.out, "%s $%04X  %-9s %s\n", mark, address, strings.Join(raw, " "), instruction)
+	return len(instruction.Bytes)
+}
@@ -0,0 +1,151 @@
+// Package disasm turns sm83 machine code into rgbds assembly. It's a linear
+// sweep, data sitting between routines comes out as instructions too
+package disasm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alaughlin/go-boi/gameboy"
+)
+
+const (
+	// BankSize is the size of a switchable rom bank
+	BankSize = 0x4000
+	// romxStart is where banks 1 and up are mapped
+	romxStart = 0x4000
+	// ramStart is the first address outside the cartridge rom
+	ramStart = 0x8000
+)
+
+// Instruction is one decoded instruction and where it sits
+type Instruction struct {
+	Bank    int
+	Address uint16
+	Bytes   []byte
+	Info    gameboy.OpcodeInfo
+	// Operand is the n8, n16, a8, a16 or e8 value that follows the opcode,
+	// a8 is already offset from 0xFF00 and e8 turned into the jr target
+	Operand uint16
+}
+
+// Decode decodes the instruction at the start of code, which is mapped at
+// address in bank. An instruction cut off by the end of code comes back as
+// a db of the bytes that are there
+func Decode(code []byte, bank int, address uint16) Instruction {
+	instruction := Instruction{Bank: bank, Address: address}
+	if len(code) == 0 {
+		return instruction
+	}
+
+	instruction.Info = gameboy.LookupOpcode(code[0], false)
+	if code[0] == 0xCB && len(code) > 1 {
+		instruction.Info = gameboy.LookupOpcode(code[1], true)
+	}
+	// a lone prefix byte is the start of a CB opcode cut off by the end of code
+	if len(code) < instruction.Info.Length || code[0] == 0xCB && len(code) == 1 {
+		instruction.Bytes = code
+		instruction.Info = gameboy.OpcodeInfo{Mnemonic: "db", Length: len(code), Illegal: true}
+		return instruction
+	}
+	instruction.Bytes = code[:instruction.Info.Length]
+
+	mnemonic := instruction.Info.Mnemonic
+	switch {
+	case strings.Contains(mnemonic, "n16") || strings.Contains(mnemonic, "a16"):
+		instruction.Operand = uint16(code[2])<<8 | uint16(code[1])
+	case strings.Contains(mnemonic, "a8"):
+		instruction.Operand = 0xFF00 | uint16(code[1])
+	case strings.HasPrefix(mnemonic, "jr"):
+		instruction.Operand = address + 2 + uint16(int8(code[1]))
+	case strings.Contains(mnemonic, "n8") || strings.Contains(mnemonic, "e8"):
+		instruction.Operand = uint16(code[1])
+	}
+	return instruction
+}
+
+// String formats the instruction with plain numbers
+func (instruction Instruction) String() string {
+	return instruction.Format(nil)
+}
+
+// Format formats the instruction, naming jump targets and memory operands
+// from symbols when it has a name for them
+func (instruction Instruction) Format(symbols *Symbols) string {
+	mnemonic := instruction.Info.Mnemonic
+	operand := instruction.Operand
+	if mnemonic == "db" {
+		values := make([]string, len(instruction.Bytes))
+		for i, n := range instruction.Bytes {
+			values[i] = fmt.Sprintf("$%02X", n)
+		}
+		return "db " + strings.Join(values, ", ")
+	}
+
+	label := func(number string) string {
+		bank := instruction.Bank
+		if instruction.Address < romxStart && operand >= romxStart && operand < ramStart {
+			// bank 0 can't know which bank is switched in
+			bank = -1
+		}
+		if name, ok := symbols.Lookup(bank, operand); ok {
+			return name
+		}
+		return number
+	}
+
+	switch {
+	case strings.Contains(mnemonic, "n16"):
+		return strings.Replace(mnemonic, "n16", fmt.Sprintf("$%04X", operand), 1)
+	case strings.Contains(mnemonic, "a16"):
+		return strings.Replace(mnemonic, "a16", label(fmt.Sprintf("$%04X", operand)), 1)
+	case strings.Contains(mnemonic, "n8"):
+		return strings.Replace(mnemonic, "n8", fmt.Sprintf("$%02X", operand), 1)
+	case strings.Contains(mnemonic, "a8"):
+		return strings.Replace(mnemonic, "a8", label(fmt.Sprintf("$%04X", operand)), 1)
+	case strings.HasPrefix(mnemonic, "jr"):
+		return strings.Replace(mnemonic, "e8", label(fmt.Sprintf("$%04X", operand)), 1)
+	case strings.Contains(mnemonic, "+e8"):
+		return strings.Replace(mnemonic, "+e8", fmt.Sprintf("%+d", int8(operand)), 1)
+	case strings.Contains(mnemonic, "e8"):
+		return strings.Replace(mnemonic, "e8", fmt.Sprintf("%d", int8(operand)), 1)
+	}
+	return mnemonic
+}
+
+// Location is the bank and cpu address a rom file offset is mapped at
+func Location(offset int) (int, uint16) {
+	if offset < BankSize {
+		return 0, uint16(offset)
+	}
+	return offset / BankSize, uint16(romxStart + offset%BankSize)
+}
+
+// Offset is where bank and address are in the rom file, the inverse of Location
+func Offset(bank int, address uint16) int {
+	if address < romxStart {
+		return int(address)
+	}
+	return bank*BankSize + int(address) - romxStart
+}
+
+// ROM decodes every instruction in rom from offset start up to end
+func ROM(rom []byte, start int, end int) []Instruction {
+	if end > len(rom) {
+		end = len(rom)
+	}
+
+	var instructions []Instruction
+	for offset := start; offset < end; {
+		bank, address := Location(offset)
+		// instructions don't run across into the next bank
+		limit := (offset/BankSize + 1) * BankSize
+		if limit > end {
+			limit = end
+		}
+		instruction := Decode(rom[offset:limit], bank, address)
+		instructions = append(instructions, instruction)
+		offset += len(instruction.Bytes)
+	}
+	return instructions
+}
@@ -0,0 +1,107 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// symbolKey is a bank and address pair as rgbds writes them
+type symbolKey struct {
+	bank    int
+	address uint16
+}
+
+// Symbols names addresses from an rgbds .sym file
+type Symbols struct {
+	names map[symbolKey]string
+	// byAddress is for lookups that don't know the bank, it's "" where
+	// more than one bank has a symbol at the address
+	byAddress map[uint16]string
+}
+
+// LoadSymbols reads an rgbds .sym file
+func LoadSymbols(path string) (*Symbols, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseSymbols(file)
+}
+
+// ParseSymbols reads lines like "01:4000 Label", anything after a ; is a comment
+func ParseSymbols(r io.Reader) (*Symbols, error) {
+	symbols := &Symbols{
+		names:     make(map[symbolKey]string),
+		byAddress: make(map[uint16]string),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		location := strings.SplitN(fields[0], ":", 2)
+		if len(fields) != 2 || len(location) != 2 {
+			return nil, fmt.Errorf("symbols line %d: expected bank:address name", number)
+		}
+		bank, err := strconv.ParseUint(location[0], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("symbols line %d: bad bank %q", number, location[0])
+		}
+		address, err := strconv.ParseUint(location[1], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("symbols line %d: bad address %q", number, location[1])
+		}
+		symbols.add(int(bank), uint16(address), fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return symbols, nil
+}
+
+// add keeps the first name given to a location
+func (symbols *Symbols) add(bank int, address uint16, name string) {
+	key := symbolKey{bank, address}
+	if _, ok := symbols.names[key]; ok {
+		return
+	}
+	symbols.names[key] = name
+
+	if other, ok := symbols.byAddress[address]; ok && other != name {
+		symbols.byAddress[address] = ""
+	} else {
+		symbols.byAddress[address] = name
+	}
+}
+
+// Lookup finds the name for address in bank, a bank of -1 means it isn't
+// known and only an address with a single symbol across all banks matches.
+// Ram symbols are found from any rom bank since the two switch separately
+func (symbols *Symbols) Lookup(bank int, address uint16) (string, bool) {
+	if symbols == nil {
+		return "", false
+	}
+	if address < romxStart {
+		bank = 0
+	}
+	if name, ok := symbols.names[symbolKey{bank, address}]; ok {
+		return name, true
+	}
+	if bank >= 0 && address < ramStart {
+		return "", false
+	}
+	name := symbols.byAddress[address]
+	return name, name != ""
+}
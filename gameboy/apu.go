@@ -0,0 +1,333 @@
+package gameboy
+
+const (
+	// SampleRate is the rate of the stereo samples the apu produces
+	SampleRate = 44100
+
+	apuStartAddr  = 0xFF10
+	apuEndAddr    = 0xFF3F
+	nr10Addr      = 0xFF10
+	nr11Addr      = 0xFF11
+	nr12Addr      = 0xFF12
+	nr13Addr      = 0xFF13
+	nr14Addr      = 0xFF14
+	nr21Addr      = 0xFF16
+	nr22Addr      = 0xFF17
+	nr23Addr      = 0xFF18
+	nr24Addr      = 0xFF19
+	nr30Addr      = 0xFF1A
+	nr31Addr      = 0xFF1B
+	nr32Addr      = 0xFF1C
+	nr33Addr      = 0xFF1D
+	nr34Addr      = 0xFF1E
+	nr41Addr      = 0xFF20
+	nr42Addr      = 0xFF21
+	nr43Addr      = 0xFF22
+	nr44Addr      = 0xFF23
+	nr50Addr      = 0xFF24
+	nr51Addr      = 0xFF25
+	nr52Addr      = 0xFF26
+	waveRAMAddr   = 0xFF30
+	waveRAMEnd    = 0xFF3F
+	apuPowerBit   = 7
+	triggerBit    = 7
+	lengthEnabled = 6
+
+	// frameSequencerCycles clocks the frame sequencer at 512 Hz
+	frameSequencerCycles = 8192
+	// maxBufferedSamples keeps a quarter second of stereo samples when nobody drains them
+	maxBufferedSamples = SampleRate / 2
+)
+
+// readMasks are or-ed into register reads since unused and write-only bits read back as 1
+var readMasks = [0x20]byte{
+	0x80, 0x3F, 0x00, 0xFF, 0xBF,
+	0xFF, 0x3F, 0x00, 0xFF, 0xBF,
+	0x7F, 0xFF, 0x9F, 0xFF, 0xBF,
+	0xFF, 0xFF, 0x00, 0x00, 0xBF,
+	0x00, 0x00, 0x70,
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+}
+
+// apu mixes two square channels, the wave channel and the noise channel into stereo samples
+type apu struct {
+	power       bool
+	registers   [0x30]byte
+	square1     *square
+	square2     *square
+	wave        *wave
+	noise       *noise
+	frameClock  int
+	frameStep   int
+	sampleClock int
+	samples     []int16
+	spare       []int16
+}
+
+func initializeAPU() *apu {
+	apu := &apu{
+		power:   true,
+		square1: &square{hasSweep: true},
+		square2: &square{},
+		wave:    &wave{},
+		noise:   &noise{},
+		samples: make([]int16, 0, maxBufferedSamples),
+		spare:   make([]int16, 0, maxBufferedSamples),
+	}
+	apu.wave.ram = apu.registers[waveRAMAddr-apuStartAddr:]
+	return apu
+}
+
+// tick runs the channels and frame sequencer and emits a sample every SampleRate-th of a second
+func (apu *apu) tick(cycles int) {
+	if apu.power {
+		apu.square1.tick(cycles)
+		apu.square2.tick(cycles)
+		apu.wave.tick(cycles)
+		apu.noise.tick(cycles)
+
+		apu.frameClock += cycles
+		for apu.frameClock >= frameSequencerCycles {
+			apu.frameClock -= frameSequencerCycles
+			apu.stepFrameSequencer()
+		}
+	}
+
+	apu.sampleClock += cycles * SampleRate
+	for apu.sampleClock >= cyclesPerSecond {
+		apu.sampleClock -= cyclesPerSecond
+		apu.mix()
+	}
+}
+
+// stepFrameSequencer clocks length counters at 256 Hz, sweep at 128 Hz and envelopes at 64 Hz
+func (apu *apu) stepFrameSequencer() {
+	if apu.frameStep%2 == 0 {
+		apu.square1.length.clock(&apu.square1.enabled)
+		apu.square2.length.clock(&apu.square2.enabled)
+		apu.wave.length.clock(&apu.wave.enabled)
+		apu.noise.length.clock(&apu.noise.enabled)
+	}
+	if apu.frameStep == 2 || apu.frameStep == 6 {
+		apu.square1.clockSweep()
+	}
+	if apu.frameStep == 7 {
+		apu.square1.envelope.clock()
+		apu.square2.envelope.clock()
+		apu.noise.envelope.clock()
+	}
+	apu.frameStep = (apu.frameStep + 1) % 8
+}
+
+// mix pans each channel with NR51, scales by the NR50 master volume and
+// appends one left/right pair to the sample buffer
+func (apu *apu) mix() {
+	if len(apu.samples)+2 > maxBufferedSamples {
+		return
+	}
+
+	outputs := [4]float64{
+		dac(apu.square1.output(), apu.square1.dacEnabled),
+		dac(apu.square2.output(), apu.square2.dacEnabled),
+		dac(apu.wave.output(), apu.wave.dacEnabled),
+		dac(apu.noise.output(), apu.noise.dacEnabled),
+	}
+
+	var left, right float64
+	if apu.power {
+		panning := apu.registers[nr51Addr-apuStartAddr]
+		for i, output := range outputs {
+			if getBit(panning, i+4) == 1 {
+				left += output
+			}
+			if getBit(panning, i) == 1 {
+				right += output
+			}
+		}
+
+		volume := apu.registers[nr50Addr-apuStartAddr]
+		left *= float64(volume>>4&0x07+1) / 8
+		right *= float64(volume&0x07+1) / 8
+	}
+
+	apu.samples = append(apu.samples, int16(left/4*0x7FFF), int16(right/4*0x7FFF))
+}
+
+// dac maps a 4-bit channel output onto -1 to 1, a disabled dac is silent
+func dac(output byte, enabled bool) float64 {
+	if !enabled {
+		return 0
+	}
+	return float64(output)/7.5 - 1
+}
+
+// drain hands over the buffered samples, they stay valid until the next drain
+func (apu *apu) drain() []int16 {
+	samples := apu.samples
+	apu.samples, apu.spare = apu.spare[:0], samples
+	return samples
+}
+
+func (apu *apu) read(address uint16) byte {
+	if address >= waveRAMAddr {
+		return apu.registers[address-apuStartAddr]
+	}
+
+	n := apu.registers[address-apuStartAddr] | readMasks[address-apuStartAddr]
+	if address == nr52Addr {
+		n &= 0xF0
+		if apu.power {
+			setBit(&n, apuPowerBit)
+		}
+		for i, enabled := range []bool{apu.square1.enabled, apu.square2.enabled, apu.wave.enabled, apu.noise.enabled} {
+			if enabled {
+				setBit(&n, i)
+			}
+		}
+	}
+	return n
+}
+
+func (apu *apu) write(address uint16, n byte) {
+	if address >= waveRAMAddr {
+		apu.registers[address-apuStartAddr] = n
+		return
+	}
+	if address == nr52Addr {
+		apu.setPower(getBit(n, apuPowerBit) == 1)
+		return
+	}
+	if !apu.power {
+		return
+	}
+
+	apu.registers[address-apuStartAddr] = n
+	switch address {
+	case nr10Addr:
+		apu.square1.writeSweep(n)
+	case nr11Addr:
+		apu.square1.writeDutyLength(n)
+	case nr12Addr:
+		apu.square1.dacEnabled = apu.square1.envelope.write(n)
+		apu.square1.enabled = apu.square1.enabled && apu.square1.dacEnabled
+	case nr13Addr:
+		apu.square1.frequency = apu.square1.frequency&0x700 | uint16(n)
+	case nr14Addr:
+		apu.square1.writeControl(n)
+	case nr21Addr:
+		apu.square2.writeDutyLength(n)
+	case nr22Addr:
+		apu.square2.dacEnabled = apu.square2.envelope.write(n)
+		apu.square2.enabled = apu.square2.enabled && apu.square2.dacEnabled
+	case nr23Addr:
+		apu.square2.frequency = apu.square2.frequency&0x700 | uint16(n)
+	case nr24Addr:
+		apu.square2.writeControl(n)
+	case nr30Addr:
+		apu.wave.dacEnabled = getBit(n, 7) == 1
+		apu.wave.enabled = apu.wave.enabled && apu.wave.dacEnabled
+	case nr31Addr:
+		apu.wave.length.load(256 - int(n))
+	case nr32Addr:
+		apu.wave.volumeCode = n >> 5 & 0x03
+	case nr33Addr:
+		apu.wave.frequency = apu.wave.frequency&0x700 | uint16(n)
+	case nr34Addr:
+		apu.wave.writeControl(n)
+	case nr41Addr:
+		apu.noise.length.load(64 - int(n&0x3F))
+	case nr42Addr:
+		apu.noise.dacEnabled = apu.noise.envelope.write(n)
+		apu.noise.enabled = apu.noise.enabled && apu.noise.dacEnabled
+	case nr43Addr:
+		apu.noise.writePolynomial(n)
+	case nr44Addr:
+		apu.noise.writeControl(n)
+	}
+}
+
+// setPower turns the apu on or off, powering off clears every register but wave ram
+func (apu *apu) setPower(on bool) {
+	if apu.power && !on {
+		for address := uint16(nr10Addr); address < nr52Addr; address++ {
+			apu.write(address, 0)
+		}
+		apu.square1.enabled = false
+		apu.square2.enabled = false
+		apu.wave.enabled = false
+		apu.noise.enabled = false
+	}
+	if !apu.power && on {
+		apu.frameStep = 0
+		apu.square1.dutyStep = 0
+		apu.square2.dutyStep = 0
+		apu.wave.position = 0
+	}
+	apu.power = on
+}
+
+// lengthCounter silences a channel once it counts down to zero
+type lengthCounter struct {
+	counter int
+	enabled bool
+	max     int
+}
+
+func (length *lengthCounter) load(counter int) {
+	length.counter = counter
+}
+
+func (length *lengthCounter) trigger() {
+	if length.counter == 0 {
+		length.counter = length.max
+	}
+}
+
+func (length *lengthCounter) clock(channelEnabled *bool) {
+	if length.enabled && length.counter > 0 {
+		length.counter--
+		if length.counter == 0 {
+			*channelEnabled = false
+		}
+	}
+}
+
+// envelope steps a channel's volume up or down every period frame sequencer ticks
+type envelope struct {
+	initial  byte
+	increase bool
+	period   byte
+	volume   byte
+	timer    byte
+}
+
+// write decodes NRx2 and reports whether the channel's dac is powered
+func (envelope *envelope) write(n byte) bool {
+	envelope.initial = n >> 4
+	envelope.increase = getBit(n, 3) == 1
+	envelope.period = n & 0x07
+	return n&0xF8 != 0
+}
+
+func (envelope *envelope) trigger() {
+	envelope.volume = envelope.initial
+	envelope.timer = envelope.period
+}
+
+func (envelope *envelope) clock() {
+	if envelope.period == 0 {
+		return
+	}
+
+	envelope.timer--
+	if envelope.timer > 0 {
+		return
+	}
+
+	envelope.timer = envelope.period
+	if envelope.increase && envelope.volume < 15 {
+		envelope.volume++
+	} else if !envelope.increase && envelope.volume > 0 {
+		envelope.volume--
+	}
+}
@@ -0,0 +1,44 @@
+package gameboy
+
+const mCycle = 4
+
+// bus is the cpu's view of memory, every access takes one m-cycle and
+// advances the rest of the system by that much before it happens so
+// timer and ppu changes land in the middle of instructions like on hardware
+type bus struct {
+	memory  *memory
+	step    func(cycles int)
+	cycles  int
+	watches []watchEntry
+	watchID int
+	// pc is where the current instruction started, only kept up to date
+	// while there are watchpoints
+	pc uint16
+}
+
+func initializeBus(memory *memory, step func(cycles int)) *bus {
+	return &bus{memory: memory, step: step}
+}
+
+// idle is an m-cycle where the cpu is busy internally and touches nothing
+func (bus *bus) idle() {
+	bus.cycles += mCycle
+	bus.step(mCycle)
+}
+
+func (bus *bus) read(address uint16) byte {
+	bus.idle()
+	n := bus.memory.read(address)
+	if bus.watches != nil {
+		bus.watch(AccessRead, address, n, n)
+	}
+	return n
+}
+
+func (bus *bus) write(address uint16, n byte) {
+	bus.idle()
+	if bus.watches != nil {
+		bus.watch(AccessWrite, address, bus.memory.read(address), n)
+	}
+	bus.memory.write(address, n)
+}
@@ -0,0 +1,188 @@
+package gameboy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	headerSize = 0x150
+
+	titleAddr          = 0x134
+	manufacturerAddr   = 0x13F
+	cgbFlagAddr        = 0x143
+	newLicenseeAddr    = 0x144
+	sgbFlagAddr        = 0x146
+	cartridgeTypeAddr  = 0x147
+	romSizeAddr        = 0x148
+	ramSizeAddr        = 0x149
+	destinationAddr    = 0x14A
+	oldLicenseeAddr    = 0x14B
+	versionAddr        = 0x14C
+	headerChecksumAddr = 0x14D
+	globalChecksumAddr = 0x14E
+	useNewLicenseeCode = 0x33
+	cgbFlagSupported   = 0x80
+	minROMSize         = 0x8000
+	maxROMSizeCode     = 0x08
+	titleLength        = 16
+	cgbTitleLength     = 11
+	manufacturerLength = 4
+)
+
+var (
+	// ErrTruncatedROM is returned when the image is too small for its header or declared size
+	ErrTruncatedROM = errors.New("rom image is truncated")
+	// ErrUnknownROMSize is returned for a rom size byte outside 0x00-0x08
+	ErrUnknownROMSize = errors.New("unknown rom size code")
+	// ErrUnknownRAMSize is returned for a ram size byte outside 0x00-0x05
+	ErrUnknownRAMSize = errors.New("unknown ram size code")
+	// ErrHeaderChecksum is returned when 0x14D doesn't match the header bytes
+	ErrHeaderChecksum = errors.New("header checksum mismatch")
+	// ErrGlobalChecksum is returned when 0x14E-0x14F doesn't match the image
+	ErrGlobalChecksum = errors.New("global checksum mismatch")
+)
+
+// ramSizes maps the header ram size byte to a size in bytes
+var ramSizes = map[byte]int{0x00: 0, 0x01: 0x800, 0x02: 0x2000, 0x03: 0x8000, 0x04: 0x20000, 0x05: 0x10000}
+
+// batteryTypes are the cartridge types whose ram survives power off
+var batteryTypes = map[byte]bool{0x03: true, 0x06: true, 0x09: true, 0x0D: true, 0x0F: true, 0x10: true, 0x13: true, 0x1B: true, 0x1E: true, 0x22: true, 0xFF: true}
+
+// rtcTypes are the mbc3 cartridge types with a real time clock
+var rtcTypes = map[byte]bool{0x0F: true, 0x10: true}
+
+// ChecksumError describes a checksum in the header that doesn't match the image
+type ChecksumError struct {
+	Err      error
+	Expected uint16
+	Actual   uint16
+}
+
+func (err *ChecksumError) Error() string {
+	return fmt.Sprintf("%v: header says %X, computed %X", err.Err, err.Expected, err.Actual)
+}
+
+func (err *ChecksumError) Unwrap() error {
+	return err.Err
+}
+
+// Header is the decoded cartridge header at 0x100-0x14F
+type Header struct {
+	Title            string
+	ManufacturerCode string
+	CGBFlag          byte
+	SGBFlag          byte
+	NewLicenseeCode  string
+	OldLicenseeCode  byte
+	CartridgeType    byte
+	ROMSize          int
+	RAMSize          int
+	DestinationCode  byte
+	Version          byte
+	HeaderChecksum   byte
+	GlobalChecksum   uint16
+}
+
+// Cartridge is a rom image along with its decoded header
+type Cartridge struct {
+	Header Header
+	ROM    []byte
+}
+
+// ParseCartridge decodes the header of a rom image, it fails on images that
+// are truncated or declare sizes that don't exist but doesn't check checksums
+func ParseCartridge(data []byte) (*Cartridge, error) {
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("%w: %d bytes is smaller than the header", ErrTruncatedROM, len(data))
+	}
+
+	romSizeCode := data[romSizeAddr]
+	if romSizeCode > maxROMSizeCode {
+		return nil, fmt.Errorf("%w: %X", ErrUnknownROMSize, romSizeCode)
+	}
+	ramSize, ok := ramSizes[data[ramSizeAddr]]
+	if !ok {
+		return nil, fmt.Errorf("%w: %X", ErrUnknownRAMSize, data[ramSizeAddr])
+	}
+
+	header := Header{
+		CGBFlag:         data[cgbFlagAddr],
+		SGBFlag:         data[sgbFlagAddr],
+		OldLicenseeCode: data[oldLicenseeAddr],
+		CartridgeType:   data[cartridgeTypeAddr],
+		ROMSize:         minROMSize << romSizeCode,
+		RAMSize:         ramSize,
+		DestinationCode: data[destinationAddr],
+		Version:         data[versionAddr],
+		HeaderChecksum:  data[headerChecksumAddr],
+		GlobalChecksum:  uint16(data[globalChecksumAddr])<<8 | uint16(data[globalChecksumAddr+1]),
+	}
+
+	// cgb era carts shortened the title to make room for the manufacturer code
+	if header.CGBFlag&cgbFlagSupported != 0 {
+		header.Title = headerString(data[titleAddr : titleAddr+cgbTitleLength])
+		header.ManufacturerCode = headerString(data[manufacturerAddr : manufacturerAddr+manufacturerLength])
+	} else {
+		header.Title = headerString(data[titleAddr : titleAddr+titleLength])
+	}
+	if header.OldLicenseeCode == useNewLicenseeCode {
+		header.NewLicenseeCode = headerString(data[newLicenseeAddr : newLicenseeAddr+2])
+	}
+
+	return &Cartridge{
+		Header: header,
+		ROM:    data,
+	}, nil
+}
+
+// HasBattery reports whether the cartridge keeps its external ram powered
+func (header Header) HasBattery() bool {
+	return batteryTypes[header.CartridgeType]
+}
+
+// HasRTC reports whether the cartridge has an mbc3 real time clock
+func (header Header) HasRTC() bool {
+	return rtcTypes[header.CartridgeType]
+}
+
+// Validate checks the image against its declared size and both checksums
+func (cartridge *Cartridge) Validate() error {
+	if len(cartridge.ROM) < cartridge.Header.ROMSize {
+		return fmt.Errorf("%w: header declares %d bytes, image has %d", ErrTruncatedROM, cartridge.Header.ROMSize, len(cartridge.ROM))
+	}
+
+	if actual := headerChecksum(cartridge.ROM); actual != cartridge.Header.HeaderChecksum {
+		return &ChecksumError{Err: ErrHeaderChecksum, Expected: uint16(cartridge.Header.HeaderChecksum), Actual: uint16(actual)}
+	}
+
+	if actual := globalChecksum(cartridge.ROM); actual != cartridge.Header.GlobalChecksum {
+		return &ChecksumError{Err: ErrGlobalChecksum, Expected: cartridge.Header.GlobalChecksum, Actual: actual}
+	}
+
+	return nil
+}
+
+func headerChecksum(data []byte) byte {
+	var checksum byte
+	for _, n := range data[titleAddr:headerChecksumAddr] {
+		checksum = checksum - n - 1
+	}
+	return checksum
+}
+
+// globalChecksum sums every byte in the image except the checksum itself
+func globalChecksum(data []byte) uint16 {
+	var checksum uint16
+	for i, n := range data {
+		if i != globalChecksumAddr && i != globalChecksumAddr+1 {
+			checksum += uint16(n)
+		}
+	}
+	return checksum
+}
+
+func headerString(data []byte) string {
+	return strings.TrimRight(string(data), "\x00 ")
+}
@@ -1,6 +1,9 @@
 package gameboy
 
 import (
+	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 )
 
@@ -9,33 +12,165 @@ type Console struct {
 	cpu     *cpu
 	memory  *memory
 	display *display
+	ppu     *ppu
+
+	link *LinkCable
+
+	cartridge     *Cartridge
+	savePath      string
+	autosaveClock int
 }
 
-// InitializeConsole initializes all the moving parts
-func InitializeConsole(romPath string, width int, height int) *Console {
+// InitializeConsole initializes all the moving parts from a rom on disk,
+// saves go next to the rom
+func InitializeConsole(romPath string, width int, height int) (*Console, error) {
+	romData, err := ioutil.ReadFile(romPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading rom: %w", err)
+	}
+
+	console, err := InitializeConsoleFromBytes(romData, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := console.SetSavePath(defaultSavePath(romPath)); err != nil {
+		return nil, fmt.Errorf("loading save: %w", err)
+	}
+	return console, nil
+}
+
+// InitializeConsoleFromBytes initializes a console from a rom image, or a
+// .zip or .gz containing one, there's no save file until SetSavePath is
+// called, width and height must be 160x144 since that's all the ppu draws
+func InitializeConsoleFromBytes(data []byte, width int, height int) (*Console, error) {
+	if width != ScreenWidth || height != ScreenHeight {
+		return nil, fmt.Errorf("%w, got %dx%d", ErrScreenSize, width, height)
+	}
+
 	console := &Console{
-		cpu:     initializeCPU(),
 		memory:  initializeMemory(),
 		display: initalizeDisplay(width, height),
 	}
 
-	console.loadGame(romPath)
-	return console
+	console.cpu = initializeCPU(initializeBus(console.memory, console.step))
+	console.ppu = initializePPU(console.display, console.memory)
+	if err := console.loadGame(data); err != nil {
+		return nil, err
+	}
+	return console, nil
+}
+
+// InitializeConsoleFromReader reads the whole rom, or a .zip or .gz containing one, from r
+func InitializeConsoleFromReader(r io.Reader, width int, height int) (*Console, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading rom: %w", err)
+	}
+	return InitializeConsoleFromBytes(data, width, height)
+}
+
+// InitializeConsoleFromFS loads the rom at name from fsys, which makes roms
+// embedded with embed.FS usable directly
+func InitializeConsoleFromFS(fsys fs.FS, name string, width int, height int) (*Console, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("reading rom: %w", err)
+	}
+	return InitializeConsoleFromBytes(data, width, height)
 }
 
-// LoadGame takes a path to a ROM and loads it into memory
-func (console *Console) loadGame(path string) {
-	romData, err := ioutil.ReadFile(path)
+// LoadGame takes a ROM image and loads it into memory
+func (console *Console) loadGame(data []byte) error {
+	romData, err := unpackROM(data)
+	if err != nil {
+		return err
+	}
+
+	cartridge, err := ParseCartridge(romData)
 	if err != nil {
-		panic("ROM not found")
+		return err
 	}
 
-	console.memory.loadGame(romData)
+	if err := console.memory.loadGame(cartridge); err != nil {
+		return err
+	}
+	console.cartridge = cartridge
+	return nil
+}
+
+// LoadBootROM maps a 256 byte dmg boot rom over the start of the cartridge
+// and starts the cpu from 0x0000 instead of the post-boot state, it needs to
+// be called before the first Tick
+func (console *Console) LoadBootROM(data []byte) error {
+	if len(data) != bootROMSize {
+		return fmt.Errorf("boot rom is %d bytes, expected %d", len(data), bootROMSize)
+	}
+
+	console.memory.bootROM = data
+	console.cpu.reset()
+	return nil
+}
+
+// SetPalette changes the colors used for the four shades
+func (console *Console) SetPalette(palette Palette) {
+	console.display.palette = palette
+}
+
+// SetLockupOnIllegalOpcode makes the illegal opcodes hang the cpu like real
+// hardware does instead of making Tick return an error
+func (console *Console) SetLockupOnIllegalOpcode(lockup bool) {
+	console.cpu.lockupOnIllegal = lockup
+}
+
+// Tick executes a single instruction and returns how many cycles it took,
+// errors are either an *OpcodeError, from writing the save file or from a
+// broken link cable
+func (console *Console) Tick() (int, error) {
+	cycles, err := console.cpu.ExecuteOpcode()
+	if err != nil {
+		return cycles, err
+	}
+
+	if console.link != nil {
+		if err := console.link.tick(cycles); err != nil {
+			return cycles, err
+		}
+	}
+	return cycles, console.autosave(cycles)
+}
+
+// step advances everything but the cpu, the cpu calls it for every m-cycle
+// of an instruction as it goes
+func (console *Console) step(cycles int) {
+	console.memory.timer.tick(cycles)
+	console.memory.serial.tick(cycles)
+	console.ppu.step(cycles)
+	console.memory.apu.tick(cycles)
+	if console.memory.rtc != nil {
+		console.memory.rtc.tick(cycles)
+	}
+}
+
+// SetButtons replaces the set of held buttons
+func (console *Console) SetButtons(buttons Button) {
+	console.memory.joypad.setButtons(buttons)
+}
+
+// PressButton holds down the given buttons, leaving the others alone
+func (console *Console) PressButton(button Button) {
+	console.memory.joypad.setButtons(console.memory.joypad.pressed | button)
+}
+
+// ReleaseButton lets go of the given buttons, leaving the others alone
+func (console *Console) ReleaseButton(button Button) {
+	console.memory.joypad.setButtons(console.memory.joypad.pressed &^ button)
 }
 
-// Tick executes a single instruction
-func (console *Console) Tick() int {
-	return console.cpu.ExecuteOpcode(console.memory)
+// AudioSamples returns the interleaved left/right samples produced since the
+// last call, the slice is reused so copy it out before calling again
+func (console *Console) AudioSamples() []int16 {
+	return console.memory.apu.drain()
 }
 
 // GetScreenData returns an array of rgba values to draw
@@ -0,0 +1,347 @@
+package gameboy
+
+import "testing"
+
+// benchmarkProgram is a loop at 0x150 mixing loads, 8 and 16 bit alu,
+// (hl) accesses, CB opcodes, the stack, a call and a relative jump
+var benchmarkProgram = []byte{
+	0x31, 0xFE, 0xDF, // ld sp,$DFFE
+	0x21, 0x00, 0xC0, // ld hl,$C000
+	// .loop
+	0x3E, 0x12, // ld a,$12
+	0x06, 0x34, // ld b,$34
+	0x80,       // add a,b
+	0x88,       // adc a,b
+	0x90,       // sub b
+	0xA8,       // xor b
+	0xB0,       // or b
+	0xFE, 0x20, // cp $20
+	0x77,       // ld (hl),a
+	0x34,       // inc (hl)
+	0x7E,       // ld a,(hl)
+	0x23,       // inc hl
+	0x2B,       // dec hl
+	0x09,       // add hl,bc
+	0xCB, 0x37, // swap a
+	0xCB, 0x7F, // bit 7,a
+	0xCB, 0xC7, // set 0,a
+	0xCB, 0x11, // rl c
+	0xC5,             // push bc
+	0xC1,             // pop bc
+	0xCD, 0x80, 0x01, // call .sub
+	0x21, 0x00, 0xC0, // ld hl,$C000
+	0x18, 0xDD, // jr .loop
+}
+
+// benchmarkSubroutine is called from the loop at 0x180
+var benchmarkSubroutine = []byte{
+	0x0D, // dec c
+	0xC9, // ret
+}
+
+func benchmarkConsole(tb testing.TB) *Console {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], []byte{0xC3, 0x50, 0x01}) // jp $0150
+	copy(rom[0x150:], benchmarkProgram)
+	copy(rom[0x180:], benchmarkSubroutine)
+
+	console, err := InitializeConsoleFromBytes(rom, 160, 144)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return console
+}
+
+func BenchmarkExecuteOpcode(b *testing.B) {
+	console := benchmarkConsole(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := console.cpu.ExecuteOpcode(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// instrTiming is the table from blargg's instr_timing in m-cycles, the
+// conditional opcodes have their not taken count and 0 is an opcode the rom
+// doesn't time
+var instrTiming = [256]int{
+	1, 3, 2, 2, 1, 1, 2, 1, 5, 2, 2, 2, 1, 1, 2, 1,
+	0, 3, 2, 2, 1, 1, 2, 1, 3, 2, 2, 2, 1, 1, 2, 1,
+	2, 3, 2, 2, 1, 1, 2, 1, 2, 2, 2, 2, 1, 1, 2, 1,
+	2, 3, 2, 2, 3, 3, 3, 1, 2, 2, 2, 2, 1, 1, 2, 1,
+	1, 1, 1, 1, 1, 1, 2, 1, 1, 1, 1, 1, 1, 1, 2, 1,
+	1, 1, 1, 1, 1, 1, 2, 1, 1, 1, 1, 1, 1, 1, 2, 1,
+	1, 1, 1, 1, 1, 1, 2, 1, 1, 1, 1, 1, 1, 1, 2, 1,
+	2, 2, 2, 2, 2, 2, 0, 2, 1, 1, 1, 1, 1, 1, 2, 1,
+	1, 1, 1, 1, 1, 1, 2, 1, 1, 1, 1, 1, 1, 1, 2, 1,
+	1, 1, 1, 1, 1, 1, 2, 1, 1, 1, 1, 1, 1, 1, 2, 1,
+	1, 1, 1, 1, 1, 1, 2, 1, 1, 1, 1, 1, 1, 1, 2, 1,
+	1, 1, 1, 1, 1, 1, 2, 1, 1, 1, 1, 1, 1, 1, 2, 1,
+	2, 3, 3, 4, 3, 4, 2, 4, 2, 4, 3, 0, 3, 6, 2, 4,
+	2, 3, 3, 0, 3, 4, 2, 4, 2, 4, 3, 0, 3, 0, 2, 4,
+	3, 3, 2, 0, 0, 4, 2, 4, 4, 1, 4, 0, 0, 0, 2, 4,
+	3, 3, 2, 1, 0, 4, 2, 4, 3, 2, 4, 1, 0, 0, 2, 4,
+}
+
+// instrTimingTaken is the count for the conditional opcodes when they branch
+var instrTimingTaken = map[byte]int{
+	0x20: 3, 0x28: 3, 0x30: 3, 0x38: 3,
+	0xC0: 5, 0xC8: 5, 0xD0: 5, 0xD8: 5,
+	0xC2: 4, 0xCA: 4, 0xD2: 4, 0xDA: 4,
+	0xC4: 6, 0xCC: 6, 0xD4: 6, 0xDC: 6,
+}
+
+// cbInstrTiming is instr_timing's table for the CB opcodes
+var cbInstrTiming = [256]int{
+	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
+	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
+	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
+	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
+	2, 2, 2, 2, 2, 2, 3, 2, 2, 2, 2, 2, 2, 2, 3, 2,
+	2, 2, 2, 2, 2, 2, 3, 2, 2, 2, 2, 2, 2, 2, 3, 2,
+	2, 2, 2, 2, 2, 2, 3, 2, 2, 2, 2, 2, 2, 2, 3, 2,
+	2, 2, 2, 2, 2, 2, 3, 2, 2, 2, 2, 2, 2, 2, 3, 2,
+	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
+	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
+	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
+	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
+	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
+	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
+	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
+	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
+}
+
+const (
+	timingCodeAddr = 0xC000
+	timingHLAddr   = 0xC100
+	timingSP       = 0xDFF0
+)
+
+// timingConsole returns a console about to run code from wram, with hl
+// pointing at timingHLAddr and sp at timingSP
+func timingConsole(t *testing.T, code ...byte) *Console {
+	console, err := InitializeConsoleFromBytes(make([]byte, 0x8000), 160, 144)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu := console.cpu
+	cpu.pc = timingCodeAddr
+	cpu.sp = timingSP
+	*cpu.h = timingHLAddr >> 8
+	*cpu.l = timingHLAddr & 0xFF
+	for i, n := range code {
+		console.memory.write(timingCodeAddr+uint16(i), n)
+	}
+	return console
+}
+
+// setCondition sets the flags so the condition of op holds or doesn't
+func setCondition(cpu *cpu, op byte, taken bool) {
+	switch cc := op >> 3 & 3; cc {
+	case 0, 1:
+		cpu.flags.Z = (cc == 1) == taken
+	case 2, 3:
+		cpu.flags.C = (cc == 3) == taken
+	}
+}
+
+// instructionCycles runs a single instruction, the operand bytes point into
+// hram so loads and stores don't touch anything with side effects
+func instructionCycles(t *testing.T, taken bool, op ...byte) int {
+	console := timingConsole(t, append(op, 0x80, 0xFF)...)
+	setCondition(console.cpu, op[len(op)-1], taken)
+
+	cycles, err := console.cpu.ExecuteOpcode()
+	if err != nil {
+		t.Fatalf("%X: %v", op, err)
+	}
+	return cycles
+}
+
+func TestInstrTiming(t *testing.T) {
+	for op, want := range instrTiming {
+		if want == 0 {
+			continue
+		}
+		if got := instructionCycles(t, false, byte(op)) / mCycle; got != want {
+			t.Errorf("%02X: %d m-cycles, want %d", op, got, want)
+		}
+		if want, ok := instrTimingTaken[byte(op)]; ok {
+			if got := instructionCycles(t, true, byte(op)) / mCycle; got != want {
+				t.Errorf("%02X taken: %d m-cycles, want %d", op, got, want)
+			}
+		}
+	}
+
+	for op, want := range cbInstrTiming {
+		if got := instructionCycles(t, false, 0xCB, byte(op)) / mCycle; got != want {
+			t.Errorf("CB %02X: %d m-cycles, want %d", op, got, want)
+		}
+	}
+}
+
+// accessMark is what the watched addresses hold during m-cycle m, none of
+// them collide with the values the instructions below write
+func accessMark(m int) byte {
+	return byte(m*0x11 + 0x05)
+}
+
+// markCycle is the m-cycle a value read from a watched address was read in
+func markCycle(n byte) int {
+	for m := 1; m <= 8; m++ {
+		if accessMark(m) == n {
+			return m
+		}
+	}
+	return 0
+}
+
+// recordAccesses runs one instruction with the watched addresses holding
+// accessMark(m) during m-cycle m, counting the opcode fetch as 1, so a read
+// returns the mark of its cycle and a write shows up as a replaced mark. It
+// returns the cycle each address was written in and the value written
+func recordAccesses(console *Console, watch ...uint16) (map[uint16]int, map[uint16]byte) {
+	memory := console.memory
+	writes := map[uint16]int{}
+	written := map[uint16]byte{}
+	m := 0
+	check := func() {
+		for _, address := range watch {
+			if n := memory.read(address); m > 0 && n != accessMark(m) {
+				writes[address] = m
+				written[address] = n
+			}
+		}
+	}
+
+	bus := console.cpu.bus
+	step := bus.step
+	bus.step = func(cycles int) {
+		check()
+		m++
+		for _, address := range watch {
+			memory.write(address, accessMark(m))
+		}
+		step(cycles)
+	}
+	defer func() { bus.step = step }()
+
+	console.cpu.ExecuteOpcode()
+	check()
+	return writes, written
+}
+
+func TestMemTiming(t *testing.T) {
+	const hl, hram, sp = timingHLAddr, 0xFF80, timingSP
+	nz := func(cpu *cpu) { cpu.flags.Z = false }
+	dispatch := func(cpu *cpu) {
+		cpu.ime = true
+		cpu.bus.memory.interrupts.enable = 1 << vblankInterrupt
+		cpu.bus.memory.interrupts.flags = 1 << vblankInterrupt
+	}
+
+	tests := []struct {
+		name  string
+		code  []byte
+		setup func(cpu *cpu)
+		watch []uint16
+		// read returns the bytes the instruction read, in the order of reads
+		read   func(cpu *cpu, written map[uint16]byte) []byte
+		reads  []int
+		writes []int
+	}{
+		{name: "ld a,(hl)", code: []byte{0x7E}, watch: []uint16{hl},
+			read:  func(cpu *cpu, _ map[uint16]byte) []byte { return []byte{*cpu.a} },
+			reads: []int{2}},
+		{name: "ld (hl),a", code: []byte{0x77}, watch: []uint16{hl}, writes: []int{2}},
+		{name: "ld (hl),n", code: []byte{0x36, 0xEE}, watch: []uint16{hl}, writes: []int{3}},
+		{name: "ldh a,(a8)", code: []byte{0xF0, 0x80}, watch: []uint16{hram},
+			read:  func(cpu *cpu, _ map[uint16]byte) []byte { return []byte{*cpu.a} },
+			reads: []int{3}},
+		{name: "ldh (a8),a", code: []byte{0xE0, 0x80}, watch: []uint16{hram}, writes: []int{3}},
+		{name: "ld a,(a16)", code: []byte{0xFA, 0x00, 0xC1}, watch: []uint16{hl},
+			read:  func(cpu *cpu, _ map[uint16]byte) []byte { return []byte{*cpu.a} },
+			reads: []int{4}},
+		{name: "ld (a16),a", code: []byte{0xEA, 0x00, 0xC1}, watch: []uint16{hl}, writes: []int{4}},
+		{name: "ld (a16),sp", code: []byte{0x08, 0x00, 0xC1}, watch: []uint16{hl, hl + 1}, writes: []int{4, 5}},
+		{name: "inc (hl)", code: []byte{0x34}, watch: []uint16{hl},
+			read:   func(_ *cpu, written map[uint16]byte) []byte { return []byte{written[hl] - 1} },
+			reads:  []int{2},
+			writes: []int{3}},
+		{name: "swap (hl)", code: []byte{0xCB, 0x36}, watch: []uint16{hl},
+			read:   func(_ *cpu, written map[uint16]byte) []byte { return []byte{written[hl]<<4 | written[hl]>>4} },
+			reads:  []int{3},
+			writes: []int{4}},
+		{name: "push bc", code: []byte{0xC5}, watch: []uint16{sp - 1, sp - 2}, writes: []int{3, 4}},
+		{name: "pop bc", code: []byte{0xC1}, watch: []uint16{sp, sp + 1},
+			read:  func(cpu *cpu, _ map[uint16]byte) []byte { return []byte{*cpu.c, *cpu.b} },
+			reads: []int{2, 3}},
+		{name: "call nn", code: []byte{0xCD, 0x00, 0xC2}, watch: []uint16{sp - 1, sp - 2}, writes: []int{5, 6}},
+		{name: "call nz,nn", code: []byte{0xC4, 0x00, 0xC2}, setup: nz, watch: []uint16{sp - 1, sp - 2}, writes: []int{5, 6}},
+		{name: "rst 38", code: []byte{0xFF}, watch: []uint16{sp - 1, sp - 2}, writes: []int{3, 4}},
+		{name: "ret", code: []byte{0xC9}, watch: []uint16{sp, sp + 1},
+			read:  func(cpu *cpu, _ map[uint16]byte) []byte { return []byte{byte(cpu.pc), byte(cpu.pc >> 8)} },
+			reads: []int{2, 3}},
+		{name: "ret nz", code: []byte{0xC0}, setup: nz, watch: []uint16{sp, sp + 1},
+			read:  func(cpu *cpu, _ map[uint16]byte) []byte { return []byte{byte(cpu.pc), byte(cpu.pc >> 8)} },
+			reads: []int{3, 4}},
+		{name: "reti", code: []byte{0xD9}, watch: []uint16{sp, sp + 1},
+			read:  func(cpu *cpu, _ map[uint16]byte) []byte { return []byte{byte(cpu.pc), byte(cpu.pc >> 8)} },
+			reads: []int{2, 3}},
+		{name: "interrupt", code: []byte{0x00}, setup: dispatch, watch: []uint16{sp - 1, sp - 2}, writes: []int{3, 4}},
+	}
+
+	for _, test := range tests {
+		console := timingConsole(t, test.code...)
+		if test.setup != nil {
+			test.setup(console.cpu)
+		}
+		writes, written := recordAccesses(console, test.watch...)
+
+		if test.read != nil {
+			for i, n := range test.read(console.cpu, written) {
+				if got := markCycle(n); got != test.reads[i] {
+					t.Errorf("%s: read %d in m-cycle %d, want %d", test.name, i+1, got, test.reads[i])
+				}
+			}
+		}
+		for i, want := range test.writes {
+			if got := writes[test.watch[i]]; got != want {
+				t.Errorf("%s: wrote %04X in m-cycle %d, want %d", test.name, test.watch[i], got, want)
+			}
+		}
+	}
+}
+
+// TestOpcodeCycles checks the cycle counts LookupOpcode reports against
+// the ones measured through the bus, both ways for conditional opcodes
+func TestOpcodeCycles(t *testing.T) {
+	for op := 0; op < 0x100; op++ {
+		info := LookupOpcode(byte(op), false)
+		if info.Illegal || op == 0xCB {
+			continue
+		}
+		if got := instructionCycles(t, false, byte(op)); got != info.Cycles {
+			t.Errorf("%02X %s: took %d cycles, LookupOpcode says %d", op, info.Mnemonic, got, info.Cycles)
+		}
+		if _, conditional := instrTimingTaken[byte(op)]; conditional != (info.BranchCycles != 0) {
+			t.Errorf("%02X %s: BranchCycles is %d", op, info.Mnemonic, info.BranchCycles)
+		}
+		if info.BranchCycles == 0 {
+			continue
+		}
+		if got := instructionCycles(t, true, byte(op)); got != info.BranchCycles {
+			t.Errorf("%02X %s taken: took %d cycles, LookupOpcode says %d", op, info.Mnemonic, got, info.BranchCycles)
+		}
+	}
+
+	for op := 0; op < 0x100; op++ {
+		info := LookupOpcode(byte(op), true)
+		if got := instructionCycles(t, false, 0xCB, byte(op)); got != info.Cycles {
+			t.Errorf("CB %02X %s: took %d cycles, LookupOpcode says %d", op, info.Mnemonic, got, info.Cycles)
+		}
+	}
+}
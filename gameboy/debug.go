@@ -0,0 +1,59 @@
+package gameboy
+
+// Registers is a copy of the cpu state for debuggers
+type Registers struct {
+	A, F   byte
+	B, C   byte
+	D, E   byte
+	H, L   byte
+	SP, PC uint16
+	IME    bool
+	Halted bool
+}
+
+// Registers returns the cpu registers as they are between instructions
+func (console *Console) Registers() Registers {
+	cpu := console.cpu
+	return Registers{
+		A:      *cpu.a,
+		F:      flagsToByte(*cpu.flags),
+		B:      *cpu.b,
+		C:      *cpu.c,
+		D:      *cpu.d,
+		E:      *cpu.e,
+		H:      *cpu.h,
+		L:      *cpu.l,
+		SP:     cpu.sp,
+		PC:     cpu.pc,
+		IME:    cpu.ime,
+		Halted: cpu.halted,
+	}
+}
+
+// SetRegisters overwrites the cpu registers, the low nibble of F always reads 0
+func (console *Console) SetRegisters(registers Registers) {
+	cpu := console.cpu
+	*cpu.a = registers.A
+	*cpu.flags = byteToFlags(registers.F)
+	*cpu.b = registers.B
+	*cpu.c = registers.C
+	*cpu.d = registers.D
+	*cpu.e = registers.E
+	*cpu.h = registers.H
+	*cpu.l = registers.L
+	cpu.sp = registers.SP
+	cpu.pc = registers.PC
+	cpu.ime = registers.IME
+	cpu.halted = registers.Halted
+}
+
+// Peek reads memory the way the cpu sees it without spending any cycles
+func (console *Console) Peek(address uint16) byte {
+	return console.memory.read(address)
+}
+
+// Poke writes memory the way the cpu would without spending any cycles,
+// writes to registers like DIV or DMA have their usual side effects
+func (console *Console) Poke(address uint16, n byte) {
+	console.memory.write(address, n)
+}
@@ -1,8 +1,29 @@
 package gameboy
 
+import "image/color"
+
+// the lcd is always this size, the ppu draws exactly this many pixels per line
+const (
+	ScreenWidth  = 160
+	ScreenHeight = 144
+)
+
+// Palette maps the four dmg shades, lightest first, to the colors drawn on screen
+type Palette [4]color.RGBA
+
+var (
+	// GreyPalette is plain greyscale
+	GreyPalette = Palette{{255, 255, 255, 255}, {170, 170, 170, 255}, {85, 85, 85, 255}, {0, 0, 0, 255}}
+	// GreenPalette approximates the original dmg lcd
+	GreenPalette = Palette{{155, 188, 15, 255}, {139, 172, 15, 255}, {48, 98, 48, 255}, {15, 56, 15, 255}}
+	// PocketPalette approximates the gameboy pocket lcd
+	PocketPalette = Palette{{196, 207, 161, 255}, {139, 149, 109, 255}, {77, 83, 60, 255}, {31, 31, 31, 255}}
+)
+
 type display struct {
 	width      int
 	height     int
+	palette    Palette
 	ScreenData []byte
 }
 
@@ -16,6 +37,17 @@ func initalizeDisplay(width int, height int) *display {
 	return &display{
 		width:      width,
 		height:     height,
+		palette:    GreyPalette,
 		ScreenData: screenData,
 	}
 }
+
+// setPixel writes one of the four dmg shades to the rgba buffer
+func (display *display) setPixel(x int, y int, shade byte) {
+	offset := (y*display.width + x) * 4
+	color := display.palette[shade]
+	display.ScreenData[offset] = color.R
+	display.ScreenData[offset+1] = color.G
+	display.ScreenData[offset+2] = color.B
+	display.ScreenData[offset+3] = color.A
+}
@@ -0,0 +1,31 @@
+package gameboy
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrIllegalOpcode is returned for the opcodes that don't exist on the sm83 and hang real hardware
+	ErrIllegalOpcode = errors.New("illegal opcode")
+	// ErrUnsupportedCartridge is returned for cartridge types without a bank controller implementation
+	ErrUnsupportedCartridge = errors.New("unsupported cartridge type")
+	// ErrScreenSize is returned when a console is asked for a screen other than 160x144
+	ErrScreenSize = errors.New("screen size must be 160x144")
+)
+
+// OpcodeError reports an opcode the cpu couldn't execute and where it was
+// fetched from, it unwraps to ErrIllegalOpcode
+type OpcodeError struct {
+	Err    error
+	PC     uint16
+	Opcode byte
+}
+
+func (err *OpcodeError) Error() string {
+	return fmt.Sprintf("%v %02X at %04X", err.Err, err.Opcode, err.PC)
+}
+
+func (err *OpcodeError) Unwrap() error {
+	return err.Err
+}
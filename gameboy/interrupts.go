@@ -0,0 +1,39 @@
+package gameboy
+
+const (
+	vblankInterrupt  = 0
+	lcdStatInterrupt = 1
+	timerInterrupt   = 2
+	serialInterrupt  = 3
+	joypadInterrupt  = 4
+
+	ifAddr = 0xFF0F
+	ieAddr = 0xFFFF
+)
+
+var interruptVectors = [5]uint16{0x40, 0x48, 0x50, 0x58, 0x60}
+
+// interrupts holds the IE and IF registers
+type interrupts struct {
+	enable byte
+	flags  byte
+}
+
+func initializeInterrupts() *interrupts {
+	return &interrupts{
+		flags: 0x01,
+	}
+}
+
+func (interrupts *interrupts) request(interrupt int) {
+	setBit(&interrupts.flags, interrupt)
+}
+
+func (interrupts *interrupts) clear(interrupt int) {
+	clearBit(&interrupts.flags, interrupt)
+}
+
+// pending returns the interrupts that are both requested and enabled
+func (interrupts *interrupts) pending() byte {
+	return interrupts.enable & interrupts.flags & 0x1F
+}
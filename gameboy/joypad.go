@@ -0,0 +1,76 @@
+package gameboy
+
+const (
+	joypAddr = 0xFF00
+
+	selectDirectionBit = 4
+	selectActionBit    = 5
+)
+
+// Button is one of the eight gameboy buttons, they can be or-ed together
+type Button byte
+
+// the low nibble holds the direction buttons and the high nibble the action
+// buttons, in the same bit order P1 reports them
+const (
+	ButtonRight Button = 1 << iota
+	ButtonLeft
+	ButtonUp
+	ButtonDown
+	ButtonA
+	ButtonB
+	ButtonSelect
+	ButtonStart
+)
+
+// joypad is the P1/JOYP register, the cpu picks a row of the button matrix
+// with bits 4 and 5 and reads the pressed buttons as 0s in the low nibble
+type joypad struct {
+	interrupts *interrupts
+	pressed    Button
+	selection  byte
+}
+
+func initializeJoypad(interrupts *interrupts) *joypad {
+	return &joypad{
+		interrupts: interrupts,
+		selection:  0x30,
+	}
+}
+
+func (joypad *joypad) read() byte {
+	return 0xC0 | joypad.selection | joypad.lines()
+}
+
+func (joypad *joypad) write(n byte) {
+	joypad.update(func() {
+		joypad.selection = n & 0x30
+	})
+}
+
+func (joypad *joypad) setButtons(buttons Button) {
+	joypad.update(func() {
+		joypad.pressed = buttons
+	})
+}
+
+// lines returns the low nibble of P1 for the selected rows
+func (joypad *joypad) lines() byte {
+	var pressed byte
+	if getBit(joypad.selection, selectDirectionBit) == 0 {
+		pressed |= byte(joypad.pressed) & 0x0F
+	}
+	if getBit(joypad.selection, selectActionBit) == 0 {
+		pressed |= byte(joypad.pressed) >> 4
+	}
+	return ^pressed & 0x0F
+}
+
+// update applies a change and requests the joypad interrupt if any line went from high to low
+func (joypad *joypad) update(change func()) {
+	before := joypad.lines()
+	change()
+	if before&^joypad.lines() != 0 {
+		joypad.interrupts.request(joypadInterrupt)
+	}
+}
@@ -0,0 +1,241 @@
+package gameboy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	linkMagic = "GBL1"
+	// both sides wait for each other every quarter frame so neither can
+	// run more than this far ahead of the other
+	linkSyncCycles = 17556
+
+	linkSync  = 0
+	linkData  = 1
+	linkReply = 2
+
+	linkMessageSize = 10
+)
+
+// ErrLinkHandshake is returned when the other end doesn't speak the link protocol
+var ErrLinkHandshake = errors.New("link cable handshake failed")
+
+// linkMessage is a kind byte, a value byte and the sender's cycle count
+type linkMessage struct {
+	kind   byte
+	value  byte
+	cycles uint64
+}
+
+// LinkCable is a SerialDevice talking to another emulator over a network
+// connection. The two sides run in lockstep: every linkSyncCycles each one
+// sends a sync message and waits for the other's. A byte sent on the
+// internal clock is stamped with the sender's cycle count and the sender
+// blocks until the other side has caught up to that cycle, clocked the byte
+// in and replied with its own
+type LinkCable struct {
+	conn     net.Conn
+	serial   *serial
+	incoming chan linkMessage
+	readErr  error
+	err      error
+	cycles   uint64
+	clock    int
+	syncs    int
+	pending  *linkMessage
+}
+
+// HostLink listens on address and waits for the other player to join
+func HostLink(address string) (*LinkCable, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewLinkCable(conn)
+}
+
+// JoinLink connects to a game hosted with HostLink
+func JoinLink(address string) (*LinkCable, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewLinkCable(conn)
+}
+
+// NewLinkCable runs the handshake over an existing connection, the cable
+// owns conn from then on
+func NewLinkCable(conn net.Conn) (*LinkCable, error) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(true)
+	}
+
+	if _, err := io.WriteString(conn, linkMagic); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	magic := make([]byte, len(linkMagic))
+	if _, err := io.ReadFull(conn, magic); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if string(magic) != linkMagic {
+		conn.Close()
+		return nil, ErrLinkHandshake
+	}
+
+	cable := &LinkCable{
+		conn:     conn,
+		incoming: make(chan linkMessage, 64),
+	}
+	go cable.readLoop()
+	return cable, nil
+}
+
+// AttachLink plugs cable into the link port, Tick returns an error once the
+// connection breaks
+func (console *Console) AttachLink(cable *LinkCable) {
+	cable.serial = console.memory.serial
+	console.link = cable
+	console.SetSerialDevice(cable)
+}
+
+// Close hangs up the connection
+func (cable *LinkCable) Close() error {
+	return cable.conn.Close()
+}
+
+// readLoop feeds messages to the emulation side until the connection closes
+func (cable *LinkCable) readLoop() {
+	for {
+		var buf [linkMessageSize]byte
+		if _, err := io.ReadFull(cable.conn, buf[:]); err != nil {
+			cable.readErr = err
+			close(cable.incoming)
+			return
+		}
+		cable.incoming <- linkMessage{
+			kind:   buf[0],
+			value:  buf[1],
+			cycles: binary.BigEndian.Uint64(buf[2:]),
+		}
+	}
+}
+
+// Exchange sends out and blocks until the other side replies with its byte
+func (cable *LinkCable) Exchange(out byte) byte {
+	if cable.err != nil || !cable.send(linkData, out) {
+		return 0xFF
+	}
+
+	for {
+		message, ok := cable.receive(true)
+		if !ok {
+			return 0xFF
+		}
+		if message.kind == linkReply {
+			return message.value
+		}
+		// the other side is blocked on us too, so answer it right away
+		cable.answer()
+	}
+}
+
+// tick counts emulated cycles, answers transfers started by the other side
+// and waits for it at every sync point
+func (cable *LinkCable) tick(cycles int) error {
+	for cable.err == nil {
+		if _, ok := cable.receive(false); !ok {
+			break
+		}
+	}
+
+	cable.cycles += uint64(cycles)
+	if cable.pending != nil && cable.cycles >= cable.pending.cycles {
+		cable.answer()
+	}
+
+	cable.clock += cycles
+	for cable.clock >= linkSyncCycles && cable.err == nil {
+		cable.clock -= linkSyncCycles
+		if !cable.send(linkSync, 0) {
+			break
+		}
+		for cable.syncs == 0 {
+			if _, ok := cable.receive(true); !ok {
+				break
+			}
+		}
+		cable.syncs--
+	}
+
+	if cable.err != nil {
+		return fmt.Errorf("link cable: %w", cable.err)
+	}
+	return nil
+}
+
+// answer clocks in the byte the other side sent and replies with ours
+func (cable *LinkCable) answer() {
+	if cable.pending == nil {
+		return
+	}
+	in := cable.pending.value
+	cable.pending = nil
+	cable.send(linkReply, cable.serial.receive(in))
+}
+
+// receive takes the next message and keeps count of syncs, a byte from the
+// other side is held until this side reaches the cycle it was sent on, ok
+// is false when there was nothing to read or the connection broke
+func (cable *LinkCable) receive(block bool) (linkMessage, bool) {
+	var message linkMessage
+	var open bool
+	if block {
+		message, open = <-cable.incoming
+	} else {
+		select {
+		case message, open = <-cable.incoming:
+		default:
+			return message, false
+		}
+	}
+
+	if !open {
+		cable.err = cable.readErr
+		return message, false
+	}
+
+	switch message.kind {
+	case linkSync:
+		cable.syncs++
+	case linkData:
+		cable.pending = &message
+		if cable.cycles >= message.cycles {
+			cable.answer()
+		}
+	}
+	return message, true
+}
+
+func (cable *LinkCable) send(kind byte, value byte) bool {
+	var buf [linkMessageSize]byte
+	buf[0] = kind
+	buf[1] = value
+	binary.BigEndian.PutUint64(buf[2:], cable.cycles)
+	if _, err := cable.conn.Write(buf[:]); err != nil {
+		cable.err = err
+		return false
+	}
+	return true
+}
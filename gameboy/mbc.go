@@ -0,0 +1,83 @@
+package gameboy
+
+import "fmt"
+
+const (
+	romBankSize = 0x4000
+	ramBankSize = 0x2000
+)
+
+// mbc is the memory bank controller on the cartridge, it handles every
+// access to 0x0000-0x7FFF and 0xA000-0xBFFF
+type mbc interface {
+	read(address uint16) byte
+	// write reports whether n was stored in external ram
+	write(address uint16, n byte) bool
+	state(codec *stateCodec)
+}
+
+// newMBC picks the controller declared by the cartridge type byte
+func newMBC(cartridge *Cartridge, ram []byte, rtc *rtc) (mbc, error) {
+	rom := cartridge.ROM
+	switch cartridgeType := cartridge.Header.CartridgeType; cartridgeType {
+	case 0x00, 0x08, 0x09:
+		return &romOnly{rom: rom, ram: ram}, nil
+	case 0x01, 0x02, 0x03:
+		return newMBC1(rom, ram), nil
+	case 0x05, 0x06:
+		return newMBC2(rom, ram), nil
+	case 0x0F, 0x10, 0x11, 0x12, 0x13:
+		return newMBC3(rom, ram, rtc), nil
+	case 0x19, 0x1A, 0x1B, 0x1C, 0x1D, 0x1E:
+		return newMBC5(rom, ram), nil
+	default:
+		return nil, fmt.Errorf("%w: %02X", ErrUnsupportedCartridge, cartridgeType)
+	}
+}
+
+// cartridgeRAMSize returns how much external ram the cartridge needs
+func cartridgeRAMSize(header Header) int {
+	switch header.CartridgeType {
+	case 0x05, 0x06:
+		return mbc2RAMSize
+	default:
+		return header.RAMSize
+	}
+}
+
+// readBank reads offset from the given bank, wrapping banks past the end of data
+func readBank(data []byte, bank int, offset uint16, bankSize int) byte {
+	if len(data) == 0 {
+		return 0xFF
+	}
+	return data[(bank*bankSize+int(offset))%len(data)]
+}
+
+// writeBank stores n at offset in the given bank, it reports false when there's no ram
+func writeBank(data []byte, bank int, offset uint16, bankSize int, n byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	data[(bank*bankSize+int(offset))%len(data)] = n
+	return true
+}
+
+// romOnly is a 32 KiB cartridge with optional unbanked ram
+type romOnly struct {
+	rom []byte
+	ram []byte
+}
+
+func (cart *romOnly) read(address uint16) byte {
+	if address < 0x8000 {
+		return readBank(cart.rom, 0, address, romBankSize)
+	}
+	return readBank(cart.ram, 0, address-0xA000, ramBankSize)
+}
+
+func (cart *romOnly) write(address uint16, n byte) bool {
+	if address >= 0xA000 {
+		return writeBank(cart.ram, 0, address-0xA000, ramBankSize, n)
+	}
+	return false
+}
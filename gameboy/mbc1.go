@@ -0,0 +1,99 @@
+package gameboy
+
+import "bytes"
+
+const (
+	logoAddr = 0x104
+	logoSize = 48
+)
+
+// mbc1 supports up to 2 MiB of rom and 32 KiB of ram, sharing the 2-bit
+// bank register between the upper rom bits and the ram bank
+type mbc1 struct {
+	rom        []byte
+	ram        []byte
+	ramEnabled bool
+	bank1      byte
+	bank2      byte
+	mode       byte
+	multicart  bool
+}
+
+func newMBC1(rom []byte, ram []byte) *mbc1 {
+	return &mbc1{
+		rom:       rom,
+		ram:       ram,
+		bank1:     1,
+		multicart: isMulticart(rom),
+	}
+}
+
+// isMulticart detects 1 MiB mbc1m carts which carry a second logo in bank 0x10
+func isMulticart(rom []byte) bool {
+	second := 0x10*romBankSize + logoAddr
+	if len(rom) != 0x100000 {
+		return false
+	}
+	return bytes.Equal(rom[logoAddr:logoAddr+logoSize], rom[second:second+logoSize])
+}
+
+// upperBits is where bank2 lands in the rom bank number, multicarts only wire 4 bits of bank1
+func (cart *mbc1) upperBits() int {
+	if cart.multicart {
+		return int(cart.bank2) << 4
+	}
+	return int(cart.bank2) << 5
+}
+
+func (cart *mbc1) lowerBits() int {
+	if cart.multicart {
+		return int(cart.bank1 & 0x0F)
+	}
+	return int(cart.bank1)
+}
+
+func (cart *mbc1) read(address uint16) byte {
+	switch {
+	case address < 0x4000:
+		bank := 0
+		if cart.mode == 1 {
+			bank = cart.upperBits()
+		}
+		return readBank(cart.rom, bank, address, romBankSize)
+	case address < 0x8000:
+		return readBank(cart.rom, cart.upperBits()|cart.lowerBits(), address-0x4000, romBankSize)
+	default:
+		if !cart.ramEnabled {
+			return 0xFF
+		}
+		return readBank(cart.ram, cart.ramBank(), address-0xA000, ramBankSize)
+	}
+}
+
+func (cart *mbc1) write(address uint16, n byte) bool {
+	switch {
+	case address < 0x2000:
+		cart.ramEnabled = n&0x0F == 0x0A
+	case address < 0x4000:
+		cart.bank1 = n & 0x1F
+		if cart.bank1 == 0 {
+			cart.bank1 = 1
+		}
+	case address < 0x6000:
+		cart.bank2 = n & 0x03
+	case address < 0x8000:
+		cart.mode = n & 0x01
+	default:
+		if cart.ramEnabled {
+			return writeBank(cart.ram, cart.ramBank(), address-0xA000, ramBankSize, n)
+		}
+	}
+	return false
+}
+
+func (cart *mbc1) ramBank() int {
+	if cart.mode == 1 {
+		return int(cart.bank2)
+	}
+	return 0
+}
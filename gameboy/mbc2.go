@@ -0,0 +1,55 @@
+package gameboy
+
+// mbc2 has 512 half-bytes of ram built into the controller
+const mbc2RAMSize = 512
+
+type mbc2 struct {
+	rom        []byte
+	ram        []byte
+	ramEnabled bool
+	romBank    byte
+}
+
+func newMBC2(rom []byte, ram []byte) *mbc2 {
+	return &mbc2{
+		rom:     rom,
+		ram:     ram,
+		romBank: 1,
+	}
+}
+
+func (cart *mbc2) read(address uint16) byte {
+	switch {
+	case address < 0x4000:
+		return readBank(cart.rom, 0, address, romBankSize)
+	case address < 0x8000:
+		return readBank(cart.rom, int(cart.romBank), address-0x4000, romBankSize)
+	default:
+		if !cart.ramEnabled {
+			return 0xFF
+		}
+		// only the lower nibble is wired up and the 512 bytes echo through 0xA000-0xBFFF
+		return readBank(cart.ram, 0, (address-0xA000)%mbc2RAMSize, mbc2RAMSize) | 0xF0
+	}
+}
+
+func (cart *mbc2) write(address uint16, n byte) bool {
+	switch {
+	case address < 0x4000:
+		// bit 8 of the address picks between ram enable and rom bank select
+		if address&0x0100 == 0 {
+			cart.ramEnabled = n&0x0F == 0x0A
+		} else {
+			cart.romBank = n & 0x0F
+			if cart.romBank == 0 {
+				cart.romBank = 1
+			}
+		}
+	case address < 0x8000:
+	default:
+		if cart.ramEnabled {
+			return writeBank(cart.ram, 0, (address-0xA000)%mbc2RAMSize, mbc2RAMSize, n&0x0F)
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package gameboy
+
+// mbc3 supports up to 2 MiB of rom and 32 KiB of ram, plus an optional
+// real time clock mapped in place of the ram banks
+type mbc3 struct {
+	rom        []byte
+	ram        []byte
+	rtc        *rtc
+	ramEnabled bool
+	romBank    byte
+	ramBank    byte
+}
+
+func newMBC3(rom []byte, ram []byte, rtc *rtc) *mbc3 {
+	return &mbc3{
+		rom:     rom,
+		ram:     ram,
+		rtc:     rtc,
+		romBank: 1,
+	}
+}
+
+func (cart *mbc3) read(address uint16) byte {
+	switch {
+	case address < 0x4000:
+		return readBank(cart.rom, 0, address, romBankSize)
+	case address < 0x8000:
+		return readBank(cart.rom, int(cart.romBank), address-0x4000, romBankSize)
+	default:
+		if !cart.ramEnabled {
+			return 0xFF
+		}
+		if cart.ramBank >= rtcSeconds && cart.ramBank <= rtcDaysHi && cart.rtc != nil {
+			return cart.rtc.read(cart.ramBank)
+		}
+		if cart.ramBank > 0x03 {
+			return 0xFF
+		}
+		return readBank(cart.ram, int(cart.ramBank), address-0xA000, ramBankSize)
+	}
+}
+
+func (cart *mbc3) write(address uint16, n byte) bool {
+	switch {
+	case address < 0x2000:
+		cart.ramEnabled = n&0x0F == 0x0A
+	case address < 0x4000:
+		cart.romBank = n & 0x7F
+		if cart.romBank == 0 {
+			cart.romBank = 1
+		}
+	case address < 0x6000:
+		cart.ramBank = n
+	case address < 0x8000:
+		if cart.rtc != nil {
+			cart.rtc.latch(n)
+		}
+	default:
+		if cart.ramEnabled && cart.ramBank >= rtcSeconds && cart.ramBank <= rtcDaysHi && cart.rtc != nil {
+			cart.rtc.write(cart.ramBank, n)
+		} else if cart.ramEnabled && cart.ramBank <= 0x03 {
+			return writeBank(cart.ram, int(cart.ramBank), address-0xA000, ramBankSize, n)
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package gameboy
+
+// mbc5 supports up to 8 MiB of rom and 128 KiB of ram, unlike the others
+// it can map rom bank 0 into 0x4000-0x7FFF
+type mbc5 struct {
+	rom        []byte
+	ram        []byte
+	ramEnabled bool
+	romBank    uint16
+	ramBank    byte
+}
+
+func newMBC5(rom []byte, ram []byte) *mbc5 {
+	return &mbc5{
+		rom:     rom,
+		ram:     ram,
+		romBank: 1,
+	}
+}
+
+func (cart *mbc5) read(address uint16) byte {
+	switch {
+	case address < 0x4000:
+		return readBank(cart.rom, 0, address, romBankSize)
+	case address < 0x8000:
+		return readBank(cart.rom, int(cart.romBank), address-0x4000, romBankSize)
+	default:
+		if !cart.ramEnabled {
+			return 0xFF
+		}
+		return readBank(cart.ram, int(cart.ramBank), address-0xA000, ramBankSize)
+	}
+}
+
+func (cart *mbc5) write(address uint16, n byte) bool {
+	switch {
+	case address < 0x2000:
+		cart.ramEnabled = n&0x0F == 0x0A
+	case address < 0x3000:
+		cart.romBank = cart.romBank&0x100 | uint16(n)
+	case address < 0x4000:
+		cart.romBank = uint16(n&0x01)<<8 | cart.romBank&0xFF
+	case address < 0x6000:
+		cart.ramBank = n & 0x0F
+	case address < 0x8000:
+	default:
+		if cart.ramEnabled {
+			return writeBank(cart.ram, int(cart.ramBank), address-0xA000, ramBankSize, n)
+		}
+	}
+	return false
+}
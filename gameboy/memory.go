@@ -1,35 +1,46 @@
 package gameboy
 
+const (
+	dmaAddr     = 0xFF46
+	bootOffAddr = 0xFF50
+	bootROMSize = 0x100
+)
+
 type memory struct {
-	bank0      *[]byte
-	bank1      *[]byte
+	bootROM    []byte
+	mbc        mbc
 	vram       *[]byte
 	eram       *[]byte
+	eramDirty  bool
+	doctorLY   bool
+	rtc        *rtc
 	wram0      *[]byte
 	wram1      *[]byte
 	oam        *[]byte
 	unusable   *[]byte
 	io         *[]byte
 	hram       *[]byte
-	interrupts *byte
+	interrupts *interrupts
+	timer      *timer
+	joypad     *joypad
+	serial     *serial
+	apu        *apu
 }
 
 func initializeMemory() *memory {
-	bank0 := make([]byte, 16384)
-	bank1 := make([]byte, 16384)
+	rom := make([]byte, 32768)
 	vram := make([]byte, 8192)
-	eram := make([]byte, 8192)
+	eram := make([]byte, 0)
 	wram0 := make([]byte, 4096)
 	wram1 := make([]byte, 4096)
 	oam := make([]byte, 160)
 	unusable := make([]byte, 96)
 	io := make([]byte, 128)
 	hram := make([]byte, 127)
-	interrupts := byte(0)
+	interrupts := initializeInterrupts()
 
 	memory := &memory{
-		bank0:      &bank0,
-		bank1:      &bank1,
+		mbc:        &romOnly{rom: rom},
 		vram:       &vram,
 		eram:       &eram,
 		wram0:      &wram0,
@@ -38,7 +49,11 @@ func initializeMemory() *memory {
 		unusable:   &unusable,
 		io:         &io,
 		hram:       &hram,
-		interrupts: &interrupts,
+		interrupts: interrupts,
+		timer:      initializeTimer(interrupts),
+		joypad:     initializeJoypad(interrupts),
+		serial:     initializeSerial(interrupts),
+		apu:        initializeAPU(),
 	}
 
 	memory.initializeValues()
@@ -68,78 +83,144 @@ func (memory *memory) initializeValues() {
 	memory.write(0xFF49, 0xFF)
 }
 
-func (memory *memory) loadGame(romData []byte) {
-	for i := range romData {
-		slice, offset := memory.mapAddress(uint16(i))
-		(*slice)[uint16(i)-offset] = romData[i]
+func (memory *memory) loadGame(cartridge *Cartridge) error {
+	eram := make([]byte, cartridgeRAMSize(cartridge.Header))
+	var rtc *rtc
+	if cartridge.Header.HasRTC() {
+		rtc = initializeRTC()
+	}
+
+	mbc, err := newMBC(cartridge, eram, rtc)
+	if err != nil {
+		return err
 	}
+
+	memory.eram = &eram
+	memory.rtc = rtc
+	memory.mbc = mbc
+	return nil
 }
 
 func (memory *memory) read(address uint16) byte {
-	if address == 0xFFFF {
-		return *memory.interrupts
+	if address < bootROMSize && memory.bootROM != nil {
+		return memory.bootROM[address]
+	} else if address < 0x8000 || (address >= 0xA000 && address < 0xC000) {
+		return memory.mbc.read(address)
+	} else if address == joypAddr {
+		return memory.joypad.read()
+	} else if address == sbAddr || address == scAddr {
+		return memory.serial.read(address)
+	} else if address >= apuStartAddr && address <= apuEndAddr {
+		return memory.apu.read(address)
+	} else if address == ieAddr {
+		return memory.interrupts.enable
+	} else if address == ifAddr {
+		return memory.interrupts.flags | 0xE0
+	} else if address >= divAddr && address <= tacAddr {
+		return memory.timer.read(address)
+	} else if address == lyAddr && memory.doctorLY {
+		return doctorLY
 	}
 
-	slice, offset := memory.mapAddress(address)
+	slice, offset, ok := memory.mapAddress(address)
+	if !ok {
+		return 0xFF
+	}
 	return (*slice)[address-offset]
 }
 
 func (memory *memory) readDouble(address uint16) uint16 {
-	return uint16(memory.read(address))<<8 | uint16(memory.read(address+1))
+	return uint16(memory.read(address+1))<<8 | uint16(memory.read(address))
 }
 
 func (memory *memory) write(address uint16, n byte) {
-	if address < 0x8000 {
-		panic("whoa no")
-	} else if address == 0xFFFF {
-		*memory.interrupts = n
-	} else {
-		slice, offset := memory.mapAddress(address)
+	if address < 0x8000 || (address >= 0xA000 && address < 0xC000) {
+		if memory.mbc.write(address, n) {
+			memory.eramDirty = true
+		}
+	} else if address == joypAddr {
+		memory.joypad.write(n)
+	} else if address == sbAddr || address == scAddr {
+		memory.serial.write(address, n)
+	} else if address >= apuStartAddr && address <= apuEndAddr {
+		memory.apu.write(address, n)
+	} else if address == ieAddr {
+		memory.interrupts.enable = n
+	} else if address == ifAddr {
+		memory.interrupts.flags = n & 0x1F
+	} else if address >= divAddr && address <= tacAddr {
+		memory.timer.write(address, n)
+	} else if address == statAddr {
+		stat := memory.read(statAddr)
+		memory.writeIO(statAddr, n&0x78|stat&0x07|0x80)
+	} else if address == dmaAddr {
+		memory.dmaTransfer(n)
+	} else if address == bootOffAddr {
+		// any write unmaps the boot rom until the next power cycle
+		memory.bootROM = nil
+		memory.writeIO(address, n|0xFE)
+	} else if slice, offset, ok := memory.mapAddress(address); ok {
 		(*slice)[address-offset] = n
 	}
 }
 
+// dmaTransfer copies 160 bytes starting at n*0x100 into oam
+func (memory *memory) dmaTransfer(n byte) {
+	(*memory.io)[dmaAddr-0xFF00] = n
+	source := uint16(n) << 8
+	for i := range *memory.oam {
+		(*memory.oam)[i] = memory.read(source + uint16(i))
+	}
+}
+
 func (memory *memory) writeDouble(address uint16, nn uint16) {
-	memory.write(address, byte(nn>>8))
-	memory.write(address+1, byte(nn&0x00FF))
+	memory.write(address, byte(nn&0x00FF))
+	memory.write(address+1, byte(nn>>8))
+}
+
+// readIO returns a hardware register as stored, ignoring what the cpu would see
+func (memory *memory) readIO(address uint16) byte {
+	return (*memory.io)[address-0xFF00]
+}
+
+// writeIO stores a hardware register without triggering cpu write side effects
+func (memory *memory) writeIO(address uint16, n byte) {
+	(*memory.io)[address-0xFF00] = n
 }
 
 func (memory *memory) decrement(address uint16) {
-	slice, offset := memory.mapAddress(address)
-	(*slice)[address-offset]--
+	if slice, offset, ok := memory.mapAddress(address); ok {
+		(*slice)[address-offset]--
+	}
 }
 
 func (memory *memory) increment(address uint16) {
-	slice, offset := memory.mapAddress(address)
-	(*slice)[address-offset]++
+	if slice, offset, ok := memory.mapAddress(address); ok {
+		(*slice)[address-offset]++
+	}
 }
 
-func (memory *memory) mapAddress(address uint16) (*[]byte, uint16) {
-	if address < 0x4000 {
-		return memory.bank0, 0
-	} else if address < 0x8000 {
-		return memory.bank1, 0x4000
-	} else if address < 0xA000 {
-		return memory.vram, 0x8000
-	} else if address < 0xC000 {
-		return memory.eram, 0xA000
-	} else if address < 0xD000 {
-		return memory.wram0, 0xC000
-	} else if address < 0xE000 {
-		return memory.wram1, 0xD000
-	} else if address < 0xEFFF {
-		return memory.wram0, 0xE000
-	} else if address < 0xFE00 {
-		return memory.wram1, 0xEFFF
-	} else if address < 0xFEA0 {
-		return memory.oam, 0xFE00
-	} else if address < 0xFF00 {
-		return memory.unusable, 0xFEA0
-	} else if address < 0xFF80 {
-		return memory.io, 0xFF00
-	} else if address < 0xFFFF {
-		return memory.hram, 0xFF80
-	} else {
-		panic("ram address out of bounds")
+// mapAddress resolves everything outside the cartridge ranges handled by the
+// mbc, ok is false for addresses with no backing slice like 0xFFFF
+func (memory *memory) mapAddress(address uint16) (*[]byte, uint16, bool) {
+	if address >= 0x8000 && address < 0xA000 {
+		return memory.vram, 0x8000, true
+	} else if address >= 0xC000 && address < 0xD000 {
+		return memory.wram0, 0xC000, true
+	} else if address >= 0xD000 && address < 0xE000 {
+		return memory.wram1, 0xD000, true
+	} else if address >= 0xE000 && address < 0xF000 {
+		return memory.wram0, 0xE000, true
+	} else if address >= 0xF000 && address < 0xFE00 {
+		return memory.wram1, 0xF000, true
+	} else if address >= 0xFE00 && address < 0xFEA0 {
+		return memory.oam, 0xFE00, true
+	} else if address >= 0xFEA0 && address < 0xFF00 {
+		return memory.unusable, 0xFEA0, true
+	} else if address >= 0xFF00 && address < 0xFF80 {
+		return memory.io, 0xFF00, true
+	} else if address >= 0xFF80 && address < 0xFFFF {
+		return memory.hram, 0xFF80, true
 	}
+	return nil, 0, false
 }
@@ -0,0 +1,59 @@
+package gameboy
+
+var noiseDivisors = [8]int{8, 16, 32, 48, 64, 80, 96, 112}
+
+// noise clocks a linear feedback shift register to make white noise
+type noise struct {
+	enabled    bool
+	dacEnabled bool
+	shift      byte
+	narrow     bool
+	divisor    byte
+	lfsr       uint16
+	timer      int
+	length     lengthCounter
+	envelope   envelope
+}
+
+func (noise *noise) period() int {
+	return noiseDivisors[noise.divisor] << noise.shift
+}
+
+func (noise *noise) tick(cycles int) {
+	noise.timer -= cycles
+	for noise.timer <= 0 {
+		noise.timer += noise.period()
+
+		feedback := (noise.lfsr ^ noise.lfsr>>1) & 1
+		noise.lfsr = noise.lfsr>>1 | feedback<<14
+		if noise.narrow {
+			// 7-bit mode also feeds back into bit 6 for a shorter, more tonal pattern
+			noise.lfsr = noise.lfsr&^(1<<6) | feedback<<6
+		}
+	}
+}
+
+func (noise *noise) output() byte {
+	if !noise.enabled || noise.lfsr&1 == 1 {
+		return 0
+	}
+	return noise.envelope.volume
+}
+
+func (noise *noise) writePolynomial(n byte) {
+	noise.shift = n >> 4
+	noise.narrow = getBit(n, 3) == 1
+	noise.divisor = n & 0x07
+}
+
+func (noise *noise) writeControl(n byte) {
+	noise.length.enabled = getBit(n, lengthEnabled) == 1
+	if getBit(n, triggerBit) == 1 {
+		noise.enabled = noise.dacEnabled
+		noise.length.max = 64
+		noise.length.trigger()
+		noise.timer = noise.period()
+		noise.envelope.trigger()
+		noise.lfsr = 0x7FFF
+	}
+}
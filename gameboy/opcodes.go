@@ -0,0 +1,358 @@
+package gameboy
+
+import "fmt"
+
+// OpcodeInfo describes an instruction for disassemblers and debuggers. The
+// mnemonic uses rgbds syntax with n8, n16, a8, a16 and e8 standing in for
+// the operand bytes that follow the opcode, a8 being an offset from 0xFF00
+// and e8 a signed offset
+type OpcodeInfo struct {
+	Mnemonic string
+	// Length counts the opcode, the 0xCB prefix and operand bytes
+	Length int
+	Cycles int
+	// BranchCycles is how long a conditional jump, call or return takes
+	// when the branch is taken, it's 0 for everything else
+	BranchCycles int
+	Illegal      bool
+}
+
+// instruction is an entry in the dispatch tables
+type instruction struct {
+	OpcodeInfo
+	execute func(cpu *cpu)
+}
+
+// opcodes and cbOpcodes are built from the operand encoding rules at init
+var (
+	opcodes   [256]instruction
+	cbOpcodes [256]instruction
+)
+
+// operand names in the order opcodes encode them
+var (
+	registerNames  = [8]string{"b", "c", "d", "e", "h", "l", "[hl]", "a"}
+	pairNames      = [4]string{"bc", "de", "hl", "sp"}
+	stackPairNames = [4]string{"bc", "de", "hl", "af"}
+	indirectNames  = [4]string{"[bc]", "[de]", "[hl+]", "[hl-]"}
+	conditionNames = [4]string{"nz", "z", "nc", "c"}
+	aluNames       = [8]string{"add", "adc", "sub", "sbc", "and", "xor", "or", "cp"}
+	rotateNames    = [8]string{"rlc", "rrc", "rl", "rr", "sla", "sra", "swap", "srl"}
+	accRotateNames = [8]string{"rlca", "rrca", "rla", "rra", "daa", "cpl", "scf", "ccf"}
+)
+
+func init() {
+	for i := range opcodes {
+		opcodes[i] = decode(byte(i))
+		cbOpcodes[i] = decodeCB(byte(i))
+	}
+}
+
+// LookupOpcode returns what's known about an opcode, prefixed looks up the
+// byte following 0xCB instead
+func LookupOpcode(opcode byte, prefixed bool) OpcodeInfo {
+	if prefixed {
+		return cbOpcodes[opcode].OpcodeInfo
+	}
+	return opcodes[opcode].OpcodeInfo
+}
+
+func op(mnemonic string, length int, cycles int, execute func(cpu *cpu)) instruction {
+	return instruction{OpcodeInfo{Mnemonic: mnemonic, Length: length, Cycles: cycles}, execute}
+}
+
+func branch(mnemonic string, length int, cycles int, branchCycles int, execute func(cpu *cpu)) instruction {
+	return instruction{OpcodeInfo{Mnemonic: mnemonic, Length: length, Cycles: cycles, BranchCycles: branchCycles}, execute}
+}
+
+func illegal(opcode byte) instruction {
+	return instruction{OpcodeInfo: OpcodeInfo{Mnemonic: fmt.Sprintf("db $%02X", opcode), Length: 1, Illegal: true}}
+}
+
+// registerCycles is the cost of an instruction with an r8 operand, more
+// when the operand is the memory at hl
+func registerCycles(index byte, cycles int, hlCycles int) int {
+	if index == regHL {
+		return hlCycles
+	}
+	return cycles
+}
+
+// decode builds the instruction for an opcode from its bit fields,
+// xxyyyzzz with y split into ppq
+func decode(opcode byte) instruction {
+	x := opcode >> 6
+	y := opcode >> 3 & 0x07
+	z := opcode & 0x07
+	p := y >> 1
+	q := y & 0x01
+
+	switch x {
+	case 0:
+		return decodeBlock0(y, z, p, q)
+	case 1:
+		if opcode == 0x76 {
+			return op("halt", 1, 4, (*cpu).halt)
+		}
+		cycles := 4
+		if y == regHL || z == regHL {
+			cycles = 8
+		}
+		return op("ld "+registerNames[y]+", "+registerNames[z], 1, cycles, func(cpu *cpu) {
+			cpu.setRegister(y, cpu.register(z))
+		})
+	case 2:
+		return op(aluNames[y]+" a, "+registerNames[z], 1, registerCycles(z, 4, 8), func(cpu *cpu) {
+			cpu.alu(y, cpu.register(z))
+		})
+	default:
+		return decodeBlock3(opcode, y, z, p, q)
+	}
+}
+
+func decodeBlock0(y byte, z byte, p byte, q byte) instruction {
+	switch z {
+	case 0:
+		switch {
+		case y == 0:
+			return op("nop", 1, 4, func(cpu *cpu) {})
+		case y == 1:
+			return op("ld [a16], sp", 3, 20, func(cpu *cpu) {
+				cpu.ld_addr_sp(cpu.fetchDouble())
+			})
+		case y == 2:
+			return op("stop", 2, 4, (*cpu).stop)
+		case y == 3:
+			return op("jr e8", 2, 12, func(cpu *cpu) {
+				cpu.jr(true)
+			})
+		default:
+			return branch("jr "+conditionNames[y-4]+", e8", 2, 8, 12, func(cpu *cpu) {
+				cpu.jr(cpu.condition(y - 4))
+			})
+		}
+	case 1:
+		if q == 0 {
+			return op("ld "+pairNames[p]+", n16", 3, 12, func(cpu *cpu) {
+				cpu.setPair(p, cpu.fetchDouble())
+			})
+		}
+		return op("add hl, "+pairNames[p], 1, 8, func(cpu *cpu) {
+			cpu.add_hl(cpu.pair(p))
+		})
+	case 2:
+		if q == 0 {
+			return op("ld "+indirectNames[p]+", a", 1, 8, func(cpu *cpu) {
+				cpu.bus.write(cpu.indirect(p), *cpu.a)
+			})
+		}
+		return op("ld a, "+indirectNames[p], 1, 8, func(cpu *cpu) {
+			*cpu.a = cpu.bus.read(cpu.indirect(p))
+		})
+	case 3:
+		if q == 0 {
+			return op("inc "+pairNames[p], 1, 8, func(cpu *cpu) {
+				cpu.bus.idle()
+				cpu.setPair(p, cpu.pair(p)+1)
+			})
+		}
+		return op("dec "+pairNames[p], 1, 8, func(cpu *cpu) {
+			cpu.bus.idle()
+			cpu.setPair(p, cpu.pair(p)-1)
+		})
+	case 4:
+		return op("inc "+registerNames[y], 1, registerCycles(y, 4, 12), func(cpu *cpu) {
+			cpu.setRegister(y, cpu.inc(cpu.register(y)))
+		})
+	case 5:
+		return op("dec "+registerNames[y], 1, registerCycles(y, 4, 12), func(cpu *cpu) {
+			cpu.setRegister(y, cpu.dec(cpu.register(y)))
+		})
+	case 6:
+		return op("ld "+registerNames[y]+", n8", 2, registerCycles(y, 8, 12), func(cpu *cpu) {
+			cpu.setRegister(y, cpu.fetch())
+		})
+	default:
+		return op(accRotateNames[y], 1, 4, func(cpu *cpu) {
+			cpu.accumulatorOp(y)
+		})
+	}
+}
+
+func decodeBlock3(opcode byte, y byte, z byte, p byte, q byte) instruction {
+	switch z {
+	case 0:
+		switch y {
+		case 4:
+			return op("ldh [a8], a", 2, 12, func(cpu *cpu) {
+				cpu.bus.write(0xFF00+uint16(cpu.fetch()), *cpu.a)
+			})
+		case 5:
+			return op("add sp, e8", 2, 16, func(cpu *cpu) {
+				cpu.sp = cpu.add_sp(cpu.fetch())
+				cpu.bus.idle()
+				cpu.bus.idle()
+			})
+		case 6:
+			return op("ldh a, [a8]", 2, 12, func(cpu *cpu) {
+				*cpu.a = cpu.bus.read(0xFF00 + uint16(cpu.fetch()))
+			})
+		case 7:
+			return op("ld hl, sp+e8", 2, 12, func(cpu *cpu) {
+				cpu.setHL(cpu.add_sp(cpu.fetch()))
+				cpu.bus.idle()
+			})
+		}
+		return branch("ret "+conditionNames[y], 1, 8, 20, func(cpu *cpu) {
+			cpu.bus.idle()
+			if cpu.condition(y) {
+				cpu.ret()
+			}
+		})
+	case 1:
+		if q == 0 {
+			if p == pairSP {
+				return op("pop af", 1, 12, func(cpu *cpu) {
+					af := cpu.pop()
+					*cpu.a = byte(af >> 8)
+					*cpu.flags = byteToFlags(byte(af))
+				})
+			}
+			return op("pop "+stackPairNames[p], 1, 12, func(cpu *cpu) {
+				cpu.setPair(p, cpu.pop())
+			})
+		}
+		switch p {
+		case 0:
+			return op("ret", 1, 16, (*cpu).ret)
+		case 1:
+			return op("reti", 1, 16, func(cpu *cpu) {
+				cpu.ret()
+				cpu.ime = true
+				cpu.imePending = false
+			})
+		case 2:
+			return op("jp hl", 1, 4, func(cpu *cpu) {
+				cpu.pc = cpu.hl()
+			})
+		default:
+			return op("ld sp, hl", 1, 8, func(cpu *cpu) {
+				cpu.bus.idle()
+				cpu.sp = cpu.hl()
+			})
+		}
+	case 2:
+		switch y {
+		case 4:
+			return op("ldh [c], a", 1, 8, func(cpu *cpu) {
+				cpu.bus.write(0xFF00+uint16(*cpu.c), *cpu.a)
+			})
+		case 5:
+			return op("ld [a16], a", 3, 16, func(cpu *cpu) {
+				cpu.bus.write(cpu.fetchDouble(), *cpu.a)
+			})
+		case 6:
+			return op("ldh a, [c]", 1, 8, func(cpu *cpu) {
+				*cpu.a = cpu.bus.read(0xFF00 + uint16(*cpu.c))
+			})
+		case 7:
+			return op("ld a, [a16]", 3, 16, func(cpu *cpu) {
+				*cpu.a = cpu.bus.read(cpu.fetchDouble())
+			})
+		}
+		return branch("jp "+conditionNames[y]+", a16", 3, 12, 16, func(cpu *cpu) {
+			cpu.jp(cpu.fetchDouble(), cpu.condition(y))
+		})
+	case 3:
+		switch y {
+		case 0:
+			return op("jp a16", 3, 16, func(cpu *cpu) {
+				cpu.jp(cpu.fetchDouble(), true)
+			})
+		case 1:
+			return op("prefix cb", 1, 4, func(cpu *cpu) {
+				cbOpcodes[cpu.fetch()].execute(cpu)
+			})
+		case 6:
+			return op("di", 1, 4, func(cpu *cpu) {
+				cpu.ime = false
+				cpu.imePending = false
+			})
+		case 7:
+			return op("ei", 1, 4, func(cpu *cpu) {
+				cpu.imePending = true
+			})
+		}
+	case 4:
+		if y < 4 {
+			return branch("call "+conditionNames[y]+", a16", 3, 12, 24, func(cpu *cpu) {
+				cpu.call(cpu.fetchDouble(), cpu.condition(y))
+			})
+		}
+	case 5:
+		if q == 0 {
+			if p == pairSP {
+				return op("push af", 1, 16, func(cpu *cpu) {
+					cpu.bus.idle()
+					cpu.push(cpu.af())
+				})
+			}
+			return op("push "+stackPairNames[p], 1, 16, func(cpu *cpu) {
+				cpu.bus.idle()
+				cpu.push(cpu.pair(p))
+			})
+		}
+		if p == 0 {
+			return op("call a16", 3, 24, func(cpu *cpu) {
+				cpu.call(cpu.fetchDouble(), true)
+			})
+		}
+	case 6:
+		return op(aluNames[y]+" a, n8", 2, 8, func(cpu *cpu) {
+			cpu.alu(y, cpu.fetch())
+		})
+	case 7:
+		vector := uint16(y) * 8
+		return op(fmt.Sprintf("rst $%02X", vector), 1, 16, func(cpu *cpu) {
+			cpu.bus.idle()
+			cpu.push(cpu.pc)
+			cpu.pc = vector
+		})
+	}
+
+	return illegal(opcode)
+}
+
+// decodeCB builds the instruction for the byte after the 0xCB prefix, the
+// prefix fetch is included in the length and cycles
+func decodeCB(opcode byte) instruction {
+	x := opcode >> 6
+	y := opcode >> 3 & 0x07
+	z := opcode & 0x07
+	bit := int(y)
+
+	switch x {
+	case 0:
+		return op(rotateNames[y]+" "+registerNames[z], 2, registerCycles(z, 8, 16), func(cpu *cpu) {
+			cpu.setRegister(z, cpu.rotate(y, cpu.register(z)))
+		})
+	case 1:
+		return op(fmt.Sprintf("bit %d, %s", bit, registerNames[z]), 2, registerCycles(z, 8, 12), func(cpu *cpu) {
+			cpu.flags.Z = getBit(cpu.register(z), bit) == 0
+			cpu.flags.N = false
+			cpu.flags.H = true
+		})
+	case 2:
+		return op(fmt.Sprintf("res %d, %s", bit, registerNames[z]), 2, registerCycles(z, 8, 16), func(cpu *cpu) {
+			n := cpu.register(z)
+			clearBit(&n, bit)
+			cpu.setRegister(z, n)
+		})
+	default:
+		return op(fmt.Sprintf("set %d, %s", bit, registerNames[z]), 2, registerCycles(z, 8, 16), func(cpu *cpu) {
+			n := cpu.register(z)
+			setBit(&n, bit)
+			cpu.setRegister(z, n)
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package gameboy
+
+const (
+	hblankMode   = 0
+	vblankMode   = 1
+	oamScanMode  = 2
+	transferMode = 3
+
+	oamScanCycles  = 80
+	transferCycles = 172
+	scanlineCycles = 456
+	visibleLines   = 144
+	totalLines     = 154
+
+	lcdcAddr = 0xFF40
+	statAddr = 0xFF41
+	scyAddr  = 0xFF42
+	scxAddr  = 0xFF43
+	lyAddr   = 0xFF44
+	lycAddr  = 0xFF45
+	bgpAddr  = 0xFF47
+	obp0Addr = 0xFF48
+	obp1Addr = 0xFF49
+	wyAddr   = 0xFF4A
+	wxAddr   = 0xFF4B
+
+	lcdEnableBit     = 7
+	windowTileMapBit = 6
+	windowEnableBit  = 5
+	tileDataBit      = 4
+	bgTileMapBit     = 3
+	objSizeBit       = 2
+	objEnableBit     = 1
+	bgEnableBit      = 0
+	coincidenceBit   = 2
+	hblankStatBit    = 3
+	vblankStatBit    = 4
+	oamStatBit       = 5
+	lycStatBit       = 6
+	tileMap0         = 0x9800
+	tileMap1         = 0x9C00
+	unsignedTileSet  = 0x8000
+	signedTileSet    = 0x9000
+
+	spriteCount    = 40
+	spritesPerLine = 10
+	bgPriorityBit  = 7
+	yFlipBit       = 6
+	xFlipBit       = 5
+	objPaletteBit  = 4
+)
+
+// sprite is a decoded oam entry
+type sprite struct {
+	index int
+	y, x  int
+	tile  byte
+	flags byte
+}
+
+// ppu walks the LCD through its modes and draws finished scanlines into the display
+type ppu struct {
+	display    *display
+	memory     *memory
+	modeClock  int
+	windowLine int
+	bgColors   []byte
+	objDrawn   []bool
+	sprites    []sprite
+	statLine   bool
+}
+
+func initializePPU(display *display, memory *memory) *ppu {
+	return &ppu{
+		display:  display,
+		memory:   memory,
+		bgColors: make([]byte, display.width),
+		objDrawn: make([]bool, display.width),
+		sprites:  make([]sprite, 0, spritesPerLine),
+	}
+}
+
+// step advances the ppu by the given number of cycles
+func (ppu *ppu) step(cycles int) {
+	if getBit(ppu.memory.read(lcdcAddr), lcdEnableBit) == 0 {
+		ppu.modeClock = 0
+		ppu.windowLine = 0
+		ppu.setLY(0)
+		ppu.setMode(hblankMode)
+		return
+	}
+
+	ppu.modeClock += cycles
+	for ppu.modeClock >= scanlineCycles {
+		ppu.modeClock -= scanlineCycles
+		ppu.setLY((ppu.memory.readIO(lyAddr) + 1) % totalLines)
+		if ppu.memory.readIO(lyAddr) == 0 {
+			ppu.windowLine = 0
+		}
+	}
+
+	ly := ppu.memory.readIO(lyAddr)
+	mode := byte(hblankMode)
+	if ly >= visibleLines {
+		mode = vblankMode
+	} else if ppu.modeClock < oamScanCycles {
+		mode = oamScanMode
+	} else if ppu.modeClock < oamScanCycles+transferCycles {
+		mode = transferMode
+	}
+
+	if mode == hblankMode && ppu.mode() != hblankMode {
+		ppu.renderScanline(ly)
+	}
+	if mode == vblankMode && ppu.mode() != vblankMode {
+		ppu.memory.interrupts.request(vblankInterrupt)
+	}
+	ppu.setMode(mode)
+	ppu.updateStatLine()
+}
+
+// updateStatLine requests the stat interrupt on the rising edge of the
+// or-ed together stat sources, so back to back sources only fire once
+func (ppu *ppu) updateStatLine() {
+	stat := ppu.memory.read(statAddr)
+	mode := stat & 0x03
+
+	line := (getBit(stat, lycStatBit) == 1 && getBit(stat, coincidenceBit) == 1) ||
+		(getBit(stat, hblankStatBit) == 1 && mode == hblankMode) ||
+		(getBit(stat, vblankStatBit) == 1 && mode == vblankMode) ||
+		(getBit(stat, oamStatBit) == 1 && mode == oamScanMode)
+
+	if line && !ppu.statLine {
+		ppu.memory.interrupts.request(lcdStatInterrupt)
+	}
+	ppu.statLine = line
+}
+
+func (ppu *ppu) mode() byte {
+	return ppu.memory.read(statAddr) & 0x03
+}
+
+func (ppu *ppu) setMode(mode byte) {
+	stat := ppu.memory.read(statAddr)
+	ppu.memory.writeIO(statAddr, stat&^0x03|mode)
+}
+
+func (ppu *ppu) setLY(ly byte) {
+	ppu.memory.writeIO(lyAddr, ly)
+
+	stat := ppu.memory.read(statAddr)
+	if ly == ppu.memory.read(lycAddr) {
+		setBit(&stat, coincidenceBit)
+	} else {
+		clearBit(&stat, coincidenceBit)
+	}
+	ppu.memory.writeIO(statAddr, stat)
+}
+
+func (ppu *ppu) renderScanline(ly byte) {
+	lcdc := ppu.memory.read(lcdcAddr)
+
+	ppu.renderBackground(lcdc, ly)
+	if getBit(lcdc, objEnableBit) == 1 {
+		ppu.renderSprites(lcdc, ly)
+	}
+}
+
+// renderBackground draws the background and window layers, remembering the
+// color ids so sprites can be placed behind them
+func (ppu *ppu) renderBackground(lcdc byte, ly byte) {
+	palette := ppu.memory.read(bgpAddr)
+
+	if getBit(lcdc, bgEnableBit) == 0 {
+		for x := 0; x < ppu.display.width; x++ {
+			ppu.bgColors[x] = 0
+			ppu.display.setPixel(x, int(ly), 0)
+		}
+		return
+	}
+
+	bgMap := uint16(tileMap0)
+	if getBit(lcdc, bgTileMapBit) == 1 {
+		bgMap = tileMap1
+	}
+	windowMap := uint16(tileMap0)
+	if getBit(lcdc, windowTileMapBit) == 1 {
+		windowMap = tileMap1
+	}
+
+	wy := ppu.memory.read(wyAddr)
+	wx := int(ppu.memory.read(wxAddr)) - 7
+	windowVisible := getBit(lcdc, windowEnableBit) == 1 && ly >= wy && wx < ppu.display.width
+
+	y := ly + ppu.memory.read(scyAddr)
+	scx := ppu.memory.read(scxAddr)
+	for x := 0; x < ppu.display.width; x++ {
+		var colorID byte
+		if windowVisible && x >= wx {
+			colorID = ppu.tilePixel(lcdc, windowMap, byte(x-wx), byte(ppu.windowLine))
+		} else {
+			colorID = ppu.tilePixel(lcdc, bgMap, byte(x)+scx, y)
+		}
+		ppu.bgColors[x] = colorID
+		ppu.display.setPixel(x, int(ly), paletteShade(palette, colorID))
+	}
+
+	if windowVisible {
+		ppu.windowLine++
+	}
+}
+
+// renderSprites draws up to ten sprites on the line on top of the background
+func (ppu *ppu) renderSprites(lcdc byte, ly byte) {
+	height := 8
+	if getBit(lcdc, objSizeBit) == 1 {
+		height = 16
+	}
+
+	sprites := ppu.spritesOnLine(int(ly), height)
+
+	// the sprite with the lowest x wins, ties go to the earlier oam entry
+	for i := 1; i < len(sprites); i++ {
+		for j := i; j > 0 && sprites[j].x < sprites[j-1].x; j-- {
+			sprites[j], sprites[j-1] = sprites[j-1], sprites[j]
+		}
+	}
+
+	drawn := ppu.objDrawn
+	for i := range drawn {
+		drawn[i] = false
+	}
+	for _, sprite := range sprites {
+		row := int(ly) - sprite.y
+		if getBit(sprite.flags, yFlipBit) == 1 {
+			row = height - 1 - row
+		}
+
+		tile := sprite.tile
+		if height == 16 {
+			tile &= 0xFE
+		}
+		rowAddr := uint16(tile)*16 + uint16(row)*2
+		lo := (*ppu.memory.vram)[rowAddr]
+		hi := (*ppu.memory.vram)[rowAddr+1]
+
+		palette := ppu.memory.read(obp0Addr)
+		if getBit(sprite.flags, objPaletteBit) == 1 {
+			palette = ppu.memory.read(obp1Addr)
+		}
+
+		for px := 0; px < 8; px++ {
+			x := sprite.x + px
+			if x < 0 || x >= ppu.display.width || drawn[x] {
+				continue
+			}
+
+			bitPos := 7 - px
+			if getBit(sprite.flags, xFlipBit) == 1 {
+				bitPos = px
+			}
+			colorID := getBit(hi, bitPos)<<1 | getBit(lo, bitPos)
+			if colorID == 0 {
+				continue
+			}
+
+			drawn[x] = true
+			if getBit(sprite.flags, bgPriorityBit) == 1 && ppu.bgColors[x] != 0 {
+				continue
+			}
+			ppu.display.setPixel(x, int(ly), paletteShade(palette, colorID))
+		}
+	}
+}
+
+// spritesOnLine scans oam in order for the first ten sprites covering the line
+func (ppu *ppu) spritesOnLine(ly int, height int) []sprite {
+	oam := *ppu.memory.oam
+	sprites := ppu.sprites[:0]
+
+	for i := 0; i < spriteCount && len(sprites) < spritesPerLine; i++ {
+		y := int(oam[i*4]) - 16
+		if ly < y || ly >= y+height {
+			continue
+		}
+
+		sprites = append(sprites, sprite{
+			index: i,
+			y:     y,
+			x:     int(oam[i*4+1]) - 8,
+			tile:  oam[i*4+2],
+			flags: oam[i*4+3],
+		})
+	}
+
+	return sprites
+}
+
+// tilePixel returns the 2-bit color id at (x, y) of the 256x256 map starting at tileMap
+func (ppu *ppu) tilePixel(lcdc byte, tileMap uint16, x byte, y byte) byte {
+	vram := *ppu.memory.vram
+	tileIndex := vram[tileMap+uint16(y/8)*32+uint16(x/8)-0x8000]
+
+	var tileAddr uint16
+	if getBit(lcdc, tileDataBit) == 1 {
+		tileAddr = unsignedTileSet + uint16(tileIndex)*16
+	} else {
+		tileAddr = uint16(int(signedTileSet) + int(int8(tileIndex))*16)
+	}
+
+	row := tileAddr + uint16(y%8)*2 - 0x8000
+	bitPos := int(7 - x%8)
+	return getBit(vram[row+1], bitPos)<<1 | getBit(vram[row], bitPos)
+}
+
+func paletteShade(palette byte, colorID byte) byte {
+	return (palette >> (colorID * 2)) & 0x03
+}
@@ -0,0 +1,179 @@
+package gameboy
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+)
+
+// rewindSnapshot is one entry in the rewind history, the newest is kept as a
+// plain save state and every older one as the flate compressed xor against
+// the state after it, which is mostly zeros from frame to frame
+type rewindSnapshot struct {
+	data []byte
+	// full is set when the state changed size, like when the boot rom
+	// unmapped, so there was nothing to xor against
+	full bool
+}
+
+// Rewinder records a save state every few frames into a ring that never
+// grows past a byte limit, dropping the oldest snapshots to make room
+type Rewinder struct {
+	console  *Console
+	interval int
+	limit    int
+	frames   int
+	newest   []byte
+	ring     []rewindSnapshot
+	start    int
+	count    int
+	size     int
+	deflate  *flate.Writer
+	buf      bytes.Buffer
+}
+
+// NewRewinder snapshots console every interval frames while keeping at
+// most limit bytes of history
+func NewRewinder(console *Console, interval int, limit int) *Rewinder {
+	if interval < 1 {
+		interval = 1
+	}
+	deflate, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return &Rewinder{
+		console:  console,
+		interval: interval,
+		limit:    limit,
+		deflate:  deflate,
+	}
+}
+
+// Interval is the number of frames between snapshots
+func (rewinder *Rewinder) Interval() int {
+	return rewinder.interval
+}
+
+// Len is the number of snapshots that can be rewound to
+func (rewinder *Rewinder) Len() int {
+	if rewinder.newest == nil {
+		return 0
+	}
+	return rewinder.count + 1
+}
+
+// Size is the memory used by the history in bytes
+func (rewinder *Rewinder) Size() int {
+	return rewinder.size + len(rewinder.newest)
+}
+
+// Frame is called once per emulated frame and takes a snapshot every interval frames
+func (rewinder *Rewinder) Frame() {
+	rewinder.frames++
+	if rewinder.frames < rewinder.interval {
+		return
+	}
+	rewinder.frames = 0
+	rewinder.push(rewinder.console.encodeState())
+}
+
+// Rewind restores the newest snapshot and drops it from the history, so
+// calling it again goes further back. It's false once the history is empty
+func (rewinder *Rewinder) Rewind() (bool, error) {
+	if rewinder.newest == nil {
+		return false, nil
+	}
+	if err := rewinder.console.LoadState(bytes.NewReader(rewinder.newest)); err != nil {
+		return false, err
+	}
+	rewinder.frames = 0
+
+	if rewinder.count == 0 {
+		rewinder.newest = nil
+		return true, nil
+	}
+	last := (rewinder.start + rewinder.count - 1) % len(rewinder.ring)
+	snapshot := rewinder.ring[last]
+	rewinder.ring[last] = rewindSnapshot{}
+	rewinder.count--
+	rewinder.size -= len(snapshot.data)
+
+	previous, err := rewinder.expand(snapshot)
+	if err != nil {
+		rewinder.Reset()
+		return true, err
+	}
+	rewinder.newest = previous
+	return true, nil
+}
+
+// Reset throws away the whole history
+func (rewinder *Rewinder) Reset() {
+	rewinder.newest = nil
+	rewinder.ring = nil
+	rewinder.start = 0
+	rewinder.count = 0
+	rewinder.size = 0
+	rewinder.frames = 0
+}
+
+// push makes state the newest snapshot and turns the one it replaces into a delta
+func (rewinder *Rewinder) push(state []byte) {
+	if rewinder.newest != nil {
+		rewinder.append(rewinder.compact(rewinder.newest, state))
+	}
+	rewinder.newest = state
+
+	for rewinder.count > 0 && rewinder.Size() > rewinder.limit {
+		rewinder.size -= len(rewinder.ring[rewinder.start].data)
+		rewinder.ring[rewinder.start] = rewindSnapshot{}
+		rewinder.start = (rewinder.start + 1) % len(rewinder.ring)
+		rewinder.count--
+	}
+}
+
+// append adds snapshot after the newest delta, doubling the ring when it's full
+func (rewinder *Rewinder) append(snapshot rewindSnapshot) {
+	if rewinder.count == len(rewinder.ring) {
+		ring := make([]rewindSnapshot, len(rewinder.ring)*2+16)
+		for i := 0; i < rewinder.count; i++ {
+			ring[i] = rewinder.ring[(rewinder.start+i)%len(rewinder.ring)]
+		}
+		rewinder.ring = ring
+		rewinder.start = 0
+	}
+	rewinder.ring[(rewinder.start+rewinder.count)%len(rewinder.ring)] = snapshot
+	rewinder.count++
+	rewinder.size += len(snapshot.data)
+}
+
+// compact stores state as a delta against next, the state that follows it
+func (rewinder *Rewinder) compact(state []byte, next []byte) rewindSnapshot {
+	full := len(state) != len(next)
+	data := state
+	if !full {
+		data = make([]byte, len(state))
+		for i := range state {
+			data[i] = state[i] ^ next[i]
+		}
+	}
+
+	rewinder.buf.Reset()
+	rewinder.deflate.Reset(&rewinder.buf)
+	rewinder.deflate.Write(data)
+	rewinder.deflate.Close()
+	return rewindSnapshot{data: append([]byte{}, rewinder.buf.Bytes()...), full: full}
+}
+
+// expand undoes compact against the current newest state
+func (rewinder *Rewinder) expand(snapshot rewindSnapshot) ([]byte, error) {
+	data, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(snapshot.data)))
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.full {
+		return data, nil
+	}
+	for i := range data {
+		data[i] ^= rewinder.newest[i]
+	}
+	return data, nil
+}
@@ -0,0 +1,83 @@
+package gameboy
+
+import (
+	"bytes"
+	"testing"
+)
+
+// runFrame ticks the console through one frame's worth of cycles
+func runFrame(tb testing.TB, console *Console) {
+	for cycles := 0; cycles < scanlineCycles*totalLines; {
+		n, err := console.Tick()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		cycles += n
+	}
+}
+
+func TestRewindRestoresSnapshots(t *testing.T) {
+	const interval = 3
+	console := benchmarkConsole(t)
+	rewinder := NewRewinder(console, interval, 1<<20)
+
+	var snapshots [][]byte
+	for frame := 1; frame <= 10*interval; frame++ {
+		runFrame(t, console)
+		rewinder.Frame()
+		if frame%interval == 0 {
+			snapshots = append(snapshots, console.encodeState())
+		}
+	}
+	if rewinder.Len() != len(snapshots) {
+		t.Fatalf("%d snapshots in the history, want %d", rewinder.Len(), len(snapshots))
+	}
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		// move away from the snapshot so restoring it has something to undo
+		runFrame(t, console)
+		ok, err := rewinder.Rewind()
+		if err != nil || !ok {
+			t.Fatalf("rewinding to snapshot %d: %v, %v", i, ok, err)
+		}
+		if !bytes.Equal(console.encodeState(), snapshots[i]) {
+			t.Fatalf("state after rewinding to snapshot %d differs from the one taken", i)
+		}
+	}
+
+	if ok, err := rewinder.Rewind(); ok || err != nil {
+		t.Errorf("rewinding past the oldest snapshot: %v, %v", ok, err)
+	}
+}
+
+func TestRewindDropsOldestPastLimit(t *testing.T) {
+	console := benchmarkConsole(t)
+	limit := len(console.encodeState()) + 1024
+	rewinder := NewRewinder(console, 1, limit)
+
+	var snapshots [][]byte
+	for frame := 0; frame < 50; frame++ {
+		runFrame(t, console)
+		rewinder.Frame()
+		snapshots = append(snapshots, console.encodeState())
+	}
+	if rewinder.Size() > limit {
+		t.Fatalf("history uses %d bytes, limit is %d", rewinder.Size(), limit)
+	}
+
+	kept := rewinder.Len()
+	if kept < 2 || kept >= len(snapshots) {
+		t.Fatalf("%d of %d snapshots kept", kept, len(snapshots))
+	}
+	for i := len(snapshots) - 1; i >= len(snapshots)-kept; i-- {
+		if _, err := rewinder.Rewind(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(console.encodeState(), snapshots[i]) {
+			t.Fatalf("state after rewinding to snapshot %d differs from the one taken", i)
+		}
+	}
+	if ok, _ := rewinder.Rewind(); ok {
+		t.Errorf("history kept more than the %d snapshots it reported", kept)
+	}
+}
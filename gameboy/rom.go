@@ -0,0 +1,62 @@
+package gameboy
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1F, 0x8B}
+
+	// ErrNoROMInArchive is returned for zip files without a .gb or .gbc entry
+	ErrNoROMInArchive = errors.New("no .gb or .gbc file in archive")
+)
+
+// unpackROM returns the rom inside a .zip or .gz container, anything else is
+// assumed to already be a raw rom image
+func unpackROM(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, zipMagic):
+		return unzipROM(data)
+	case bytes.HasPrefix(data, gzipMagic):
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("opening gzip: %w", err)
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	default:
+		return data, nil
+	}
+}
+
+// unzipROM extracts the first .gb or .gbc entry in the archive
+func unzipROM(data []byte) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("opening zip: %w", err)
+	}
+
+	for _, file := range archive.File {
+		ext := strings.ToLower(path.Ext(file.Name))
+		if ext != ".gb" && ext != ".gbc" {
+			continue
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("opening %s in zip: %w", file.Name, err)
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	}
+
+	return nil, ErrNoROMInArchive
+}
@@ -0,0 +1,201 @@
+package gameboy
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// RTCMode selects what drives the mbc3 real time clock
+type RTCMode int
+
+const (
+	// RTCWallClock advances the clock with the host's time, even while the emulator is closed
+	RTCWallClock RTCMode = iota
+	// RTCEmulated advances the clock from emulated cycles so runs are deterministic
+	RTCEmulated
+)
+
+const (
+	rtcSeconds = 0x08
+	rtcMinutes = 0x09
+	rtcHours   = 0x0A
+	rtcDaysLow = 0x0B
+	rtcDaysHi  = 0x0C
+
+	rtcHaltBit  = 6
+	rtcCarryBit = 7
+
+	cyclesPerSecond = 4194304
+	// rtcFooterSize is the bgb/vba layout: 5 current and 5 latched registers as
+	// little endian uint32s followed by a 64-bit unix timestamp
+	rtcFooterSize = 48
+)
+
+// rtc is the clock inside mbc3 cartridges like pokemon gold/silver
+type rtc struct {
+	mode      RTCMode
+	seconds   byte
+	minutes   byte
+	hours     byte
+	days      uint16
+	halt      bool
+	carry     bool
+	latched   [5]byte
+	latchLast byte
+	cycles    int
+	lastTime  int64
+	now       func() time.Time
+	// dirty is set when the game writes the registers, time passing on its
+	// own doesn't need saving since the footer's timestamp covers it
+	dirty bool
+}
+
+func initializeRTC() *rtc {
+	rtc := &rtc{
+		latchLast: 0xFF,
+		now:       time.Now,
+	}
+	rtc.lastTime = rtc.now().Unix()
+	return rtc
+}
+
+// SetRTCMode picks whether the cartridge clock follows the host or emulated
+// time. Call it before SetSavePath, leaving the wall clock catches up on the
+// time since the save was written first
+func (console *Console) SetRTCMode(mode RTCMode) {
+	if console.memory.rtc != nil {
+		console.memory.rtc.setMode(mode)
+	}
+}
+
+// setMode switches the clock source, catching up on wall time first so nothing is lost
+func (rtc *rtc) setMode(mode RTCMode) {
+	rtc.sync()
+	rtc.mode = mode
+	rtc.cycles = 0
+	rtc.lastTime = rtc.now().Unix()
+}
+
+// tick advances the emulated clock, it's a no-op for the wall clock
+func (rtc *rtc) tick(cycles int) {
+	if rtc.mode != RTCEmulated {
+		return
+	}
+
+	rtc.cycles += cycles
+	for rtc.cycles >= cyclesPerSecond {
+		rtc.cycles -= cyclesPerSecond
+		rtc.advance(1)
+	}
+}
+
+// sync brings the wall clock up to date with the host
+func (rtc *rtc) sync() {
+	if rtc.mode != RTCWallClock {
+		return
+	}
+
+	now := rtc.now().Unix()
+	if now > rtc.lastTime {
+		rtc.advance(now - rtc.lastTime)
+	}
+	rtc.lastTime = now
+}
+
+func (rtc *rtc) advance(seconds int64) {
+	if rtc.halt {
+		return
+	}
+
+	total := int64(rtc.seconds) + seconds
+	rtc.seconds = byte(total % 60)
+	total = int64(rtc.minutes) + total/60
+	rtc.minutes = byte(total % 60)
+	total = int64(rtc.hours) + total/60
+	rtc.hours = byte(total % 24)
+	total = int64(rtc.days) + total/24
+	if total > 0x1FF {
+		rtc.carry = true
+	}
+	rtc.days = uint16(total & 0x1FF)
+}
+
+func (rtc *rtc) registers() [5]byte {
+	daysHi := byte(rtc.days>>8) & 0x01
+	if rtc.halt {
+		setBit(&daysHi, rtcHaltBit)
+	}
+	if rtc.carry {
+		setBit(&daysHi, rtcCarryBit)
+	}
+	return [5]byte{rtc.seconds, rtc.minutes, rtc.hours, byte(rtc.days), daysHi}
+}
+
+// latch copies the live registers on a 0x00 then 0x01 write to 0x6000-0x7FFF
+func (rtc *rtc) latch(n byte) {
+	if rtc.latchLast == 0x00 && n == 0x01 {
+		rtc.sync()
+		rtc.latched = rtc.registers()
+	}
+	rtc.latchLast = n
+}
+
+func (rtc *rtc) read(register byte) byte {
+	return rtc.latched[register-rtcSeconds]
+}
+
+func (rtc *rtc) write(register byte, n byte) {
+	rtc.sync()
+
+	switch register {
+	case rtcSeconds:
+		rtc.seconds = n & 0x3F
+		rtc.cycles = 0
+	case rtcMinutes:
+		rtc.minutes = n & 0x3F
+	case rtcHours:
+		rtc.hours = n & 0x1F
+	case rtcDaysLow:
+		rtc.days = rtc.days&0x100 | uint16(n)
+	case rtcDaysHi:
+		rtc.days = uint16(n&0x01)<<8 | rtc.days&0xFF
+		rtc.halt = getBit(n, rtcHaltBit) == 1
+		rtc.carry = getBit(n, rtcCarryBit) == 1
+	}
+	rtc.latched[register-rtcSeconds] = rtc.registers()[register-rtcSeconds]
+	rtc.dirty = true
+}
+
+// footer encodes the clock in the 48 byte format appended to .sav files
+func (rtc *rtc) footer() []byte {
+	rtc.sync()
+
+	footer := make([]byte, rtcFooterSize)
+	for i, n := range rtc.registers() {
+		binary.LittleEndian.PutUint32(footer[i*4:], uint32(n))
+	}
+	for i, n := range rtc.latched {
+		binary.LittleEndian.PutUint32(footer[20+i*4:], uint32(n))
+	}
+	binary.LittleEndian.PutUint64(footer[40:], uint64(rtc.lastTime))
+	return footer
+}
+
+// loadFooter restores the clock, on the wall clock the time that passed since
+// the save was written is caught up on the next sync
+func (rtc *rtc) loadFooter(footer []byte) {
+	var registers [5]byte
+	for i := range registers {
+		registers[i] = byte(binary.LittleEndian.Uint32(footer[i*4:]))
+		rtc.latched[i] = byte(binary.LittleEndian.Uint32(footer[20+i*4:]))
+	}
+
+	rtc.seconds = registers[0]
+	rtc.minutes = registers[1]
+	rtc.hours = registers[2]
+	rtc.days = uint16(registers[4]&0x01)<<8 | uint16(registers[3])
+	rtc.halt = getBit(registers[4], rtcHaltBit) == 1
+	rtc.carry = getBit(registers[4], rtcCarryBit) == 1
+	rtc.lastTime = int64(binary.LittleEndian.Uint64(footer[40:]))
+	rtc.dirty = false
+}
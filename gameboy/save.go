@@ -0,0 +1,107 @@
+package gameboy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// autosaveCycles is roughly one second of emulated time
+const autosaveCycles = 4194304
+
+// defaultSavePath puts the .sav file next to the rom like other emulators
+func defaultSavePath(romPath string) string {
+	return strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sav"
+}
+
+// SetSavePath changes where external ram is persisted and loads any save
+// already there. Unsaved changes are flushed to the old path first, and if
+// there's no file at the new one the ram loaded so far stays as it is
+func (console *Console) SetSavePath(path string) error {
+	if err := console.Flush(); err != nil {
+		return err
+	}
+	console.savePath = path
+	return console.loadSave()
+}
+
+// loadSave copies a raw .sav dump into external ram, a missing file just means a fresh game
+func (console *Console) loadSave() error {
+	if !console.cartridge.Header.HasBattery() || console.savePath == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(console.savePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	copy(*console.memory.eram, data)
+	if console.memory.rtc != nil && len(data) >= len(*console.memory.eram)+rtcFooterSize {
+		console.memory.rtc.loadFooter(data[len(*console.memory.eram):])
+	}
+	console.memory.eramDirty = false
+	return nil
+}
+
+// Flush writes external ram to the save file if it or the clock registers
+// changed since the last flush
+func (console *Console) Flush() error {
+	return console.flush(false)
+}
+
+// flush writes the save file, unless nothing changed and force isn't set
+func (console *Console) flush(force bool) error {
+	if !console.cartridge.Header.HasBattery() || console.savePath == "" {
+		return nil
+	}
+	rtc := console.memory.rtc
+	if !force && !console.memory.eramDirty && (rtc == nil || !rtc.dirty) {
+		return nil
+	}
+
+	data := *console.memory.eram
+	if rtc != nil {
+		data = append(append([]byte{}, data...), rtc.footer()...)
+	}
+	if err := writeFileAtomic(console.savePath, data); err != nil {
+		return err
+	}
+
+	console.memory.eramDirty = false
+	if rtc != nil {
+		rtc.dirty = false
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// over path, so a crash halfway through never leaves a truncated save behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Close writes the save file one last time, with the clock's current
+// timestamp, call it before throwing the console away
+func (console *Console) Close() error {
+	return console.flush(true)
+}
+
+// autosave flushes external ram about once a second of emulated time
+func (console *Console) autosave(cycles int) error {
+	console.autosaveClock += cycles
+	if console.autosaveClock < autosaveCycles {
+		return nil
+	}
+
+	console.autosaveClock = 0
+	return console.Flush()
+}
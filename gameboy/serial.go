@@ -0,0 +1,158 @@
+package gameboy
+
+import "io"
+
+const (
+	sbAddr = 0xFF01
+	scAddr = 0xFF02
+
+	transferStartBit = 7
+	internalClockBit = 0
+	// the internal clock shifts one bit every 512 cycles, 8192Hz
+	serialBitCycles = 512
+)
+
+// SerialDevice is whatever is plugged into the link port, when this console
+// drives the clock Exchange gets the byte shifted out and returns the byte
+// shifted back in, 0xFF is what an empty port reads as
+type SerialDevice interface {
+	Exchange(out byte) byte
+}
+
+// serial models SB/SC, a transfer on the internal clock finishes 8 bits
+// later, one on the external clock waits for the other side to drive it
+type serial struct {
+	interrupts *interrupts
+	device     SerialDevice
+	sb         byte
+	sc         byte
+	clock      int
+	bits       int
+}
+
+func initializeSerial(interrupts *interrupts) *serial {
+	return &serial{interrupts: interrupts}
+}
+
+func (serial *serial) read(address uint16) byte {
+	if address == sbAddr {
+		return serial.sb
+	}
+	return serial.sc | 0x7E
+}
+
+func (serial *serial) write(address uint16, n byte) {
+	if address == sbAddr {
+		serial.sb = n
+		return
+	}
+
+	serial.sc = n & 0x81
+	serial.clock = 0
+	serial.bits = 0
+}
+
+// transferring is true while a transfer is waiting for its clock
+func (serial *serial) transferring() bool {
+	return getBit(serial.sc, transferStartBit) == 1
+}
+
+func (serial *serial) internalClock() bool {
+	return getBit(serial.sc, internalClockBit) == 1
+}
+
+// tick shifts bits out when this side drives the clock
+func (serial *serial) tick(cycles int) {
+	if !serial.transferring() || !serial.internalClock() {
+		return
+	}
+
+	serial.clock += cycles
+	for serial.clock >= serialBitCycles && serial.transferring() {
+		serial.clock -= serialBitCycles
+		serial.bits++
+		if serial.bits == 8 {
+			in := byte(0xFF)
+			if serial.device != nil {
+				in = serial.device.Exchange(serial.sb)
+			}
+			serial.sb = in
+			serial.finish()
+		}
+	}
+}
+
+// receive is the other side clocking a whole byte into us, it only lands
+// if a transfer on the external clock is waiting, otherwise the line reads high
+func (serial *serial) receive(in byte) byte {
+	if !serial.transferring() || serial.internalClock() {
+		return 0xFF
+	}
+
+	out := serial.sb
+	serial.sb = in
+	serial.finish()
+	return out
+}
+
+func (serial *serial) finish() {
+	clearBit(&serial.sc, transferStartBit)
+	serial.clock = 0
+	serial.bits = 0
+	serial.interrupts.request(serialInterrupt)
+}
+
+// SetSerialDevice plugs device into the link port, nil unplugs it
+func (console *Console) SetSerialDevice(device SerialDevice) {
+	console.memory.serial.device = device
+}
+
+// LinkConsoles connects two consoles in the same process with a link cable,
+// whichever one uses the internal clock drives the transfer
+func LinkConsoles(a *Console, b *Console) {
+	a.SetSerialDevice(consolePort{peer: b.memory.serial})
+	b.SetSerialDevice(consolePort{peer: a.memory.serial})
+}
+
+// consolePort is one end of a cable between two consoles
+type consolePort struct {
+	peer *serial
+}
+
+func (port consolePort) Exchange(out byte) byte {
+	return port.peer.receive(out)
+}
+
+// SerialLoopback wires the output straight back to the input
+type SerialLoopback struct{}
+
+// Exchange returns the byte that was sent
+func (SerialLoopback) Exchange(out byte) byte {
+	return out
+}
+
+// SerialWriter writes every byte sent to w, which is how test roms like
+// blargg's report their results
+type SerialWriter struct {
+	w   io.Writer
+	err error
+}
+
+// NewSerialWriter returns a device that writes sent bytes to w
+func NewSerialWriter(w io.Writer) *SerialWriter {
+	return &SerialWriter{w: w}
+}
+
+// Exchange writes out and reads back an empty port, after the first write
+// error it stops writing
+func (writer *SerialWriter) Exchange(out byte) byte {
+	if writer.err == nil {
+		_, writer.err = writer.w.Write([]byte{out})
+	}
+	return 0xFF
+}
+
+// Err returns the first error hit while writing
+func (writer *SerialWriter) Err() error {
+	return writer.err
+}
@@ -0,0 +1,127 @@
+package gameboy
+
+// dutyPatterns are the 12.5%, 25%, 50% and 75% waveforms
+var dutyPatterns = [4][8]byte{
+	{0, 0, 0, 0, 0, 0, 0, 1},
+	{1, 0, 0, 0, 0, 0, 0, 1},
+	{1, 0, 0, 0, 0, 1, 1, 1},
+	{0, 1, 1, 1, 1, 1, 1, 0},
+}
+
+// square is a pulse channel, channel 1 also has a frequency sweep
+type square struct {
+	enabled    bool
+	dacEnabled bool
+	duty       byte
+	dutyStep   int
+	frequency  uint16
+	timer      int
+	length     lengthCounter
+	envelope   envelope
+
+	hasSweep     bool
+	sweepPeriod  byte
+	sweepNegate  bool
+	sweepShift   byte
+	sweepTimer   byte
+	sweepEnabled bool
+	shadowFreq   uint16
+}
+
+func (square *square) tick(cycles int) {
+	square.timer -= cycles
+	for square.timer <= 0 {
+		square.timer += int(2048-square.frequency) * 4
+		square.dutyStep = (square.dutyStep + 1) % 8
+	}
+}
+
+func (square *square) output() byte {
+	if !square.enabled {
+		return 0
+	}
+	return dutyPatterns[square.duty][square.dutyStep] * square.envelope.volume
+}
+
+func (square *square) writeSweep(n byte) {
+	square.sweepPeriod = n >> 4 & 0x07
+	square.sweepNegate = getBit(n, 3) == 1
+	square.sweepShift = n & 0x07
+}
+
+func (square *square) writeDutyLength(n byte) {
+	square.duty = n >> 6
+	square.length.max = 64
+	square.length.load(64 - int(n&0x3F))
+}
+
+// writeControl handles NRx4, the upper frequency bits, length enable and trigger
+func (square *square) writeControl(n byte) {
+	square.frequency = uint16(n&0x07)<<8 | square.frequency&0xFF
+	square.length.enabled = getBit(n, lengthEnabled) == 1
+	if getBit(n, triggerBit) == 1 {
+		square.trigger()
+	}
+}
+
+func (square *square) trigger() {
+	square.enabled = square.dacEnabled
+	square.length.max = 64
+	square.length.trigger()
+	square.timer = int(2048-square.frequency) * 4
+	square.envelope.trigger()
+
+	if square.hasSweep {
+		square.shadowFreq = square.frequency
+		square.sweepTimer = sweepReload(square.sweepPeriod)
+		square.sweepEnabled = square.sweepPeriod != 0 || square.sweepShift != 0
+		if square.sweepShift != 0 {
+			square.calculateSweep()
+		}
+	}
+}
+
+// clockSweep recalculates the frequency every sweep period frame sequencer ticks
+func (square *square) clockSweep() {
+	if !square.hasSweep {
+		return
+	}
+
+	square.sweepTimer--
+	if square.sweepTimer > 0 {
+		return
+	}
+
+	square.sweepTimer = sweepReload(square.sweepPeriod)
+	if !square.sweepEnabled || square.sweepPeriod == 0 {
+		return
+	}
+
+	frequency := square.calculateSweep()
+	if frequency <= 2047 && square.sweepShift != 0 {
+		square.frequency = frequency
+		square.shadowFreq = frequency
+		square.calculateSweep()
+	}
+}
+
+// calculateSweep returns the next frequency and disables the channel if it overflows
+func (square *square) calculateSweep() uint16 {
+	delta := square.shadowFreq >> square.sweepShift
+	frequency := square.shadowFreq + delta
+	if square.sweepNegate {
+		frequency = square.shadowFreq - delta
+	}
+	if frequency > 2047 {
+		square.enabled = false
+	}
+	return frequency
+}
+
+// sweepReload treats a period of 0 as 8
+func sweepReload(period byte) byte {
+	if period == 0 {
+		return 8
+	}
+	return period
+}
@@ -0,0 +1,473 @@
+package gameboy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// Version is the emulator version recorded in save states
+	Version = "0.1.0"
+
+	stateMagic = "GBST"
+	// stateFormat goes up when existing sections change layout, adding a
+	// section or appending fields to one doesn't need a bump since unknown
+	// sections and trailing bytes are skipped
+	stateFormat = 1
+)
+
+var (
+	// ErrInvalidState is returned for data that isn't a save state or is cut short
+	ErrInvalidState = errors.New("invalid save state")
+	// ErrStateVersion is returned for save states written in a newer format
+	ErrStateVersion = errors.New("save state is from a newer version")
+	// ErrStateMismatch is returned for save states made with a different rom
+	ErrStateMismatch = errors.New("save state is for a different rom")
+)
+
+// stateCodec either writes fields to data or reads them back from it, so a
+// single function per component describes its layout for both directions
+type stateCodec struct {
+	loading bool
+	data    []byte
+	err     error
+}
+
+// fixed returns the next size bytes to decode, or appends size bytes to be
+// encoded into, it's nil once the input has run out
+func (codec *stateCodec) fixed(size int) []byte {
+	if !codec.loading {
+		codec.data = append(codec.data, make([]byte, size)...)
+		return codec.data[len(codec.data)-size:]
+	}
+
+	if codec.err != nil {
+		return nil
+	}
+	if len(codec.data) < size {
+		codec.err = ErrInvalidState
+		return nil
+	}
+	b := codec.data[:size]
+	codec.data = codec.data[size:]
+	return b
+}
+
+func (codec *stateCodec) u8(n *byte) {
+	if b := codec.fixed(1); b == nil {
+		return
+	} else if codec.loading {
+		*n = b[0]
+	} else {
+		b[0] = *n
+	}
+}
+
+func (codec *stateCodec) u16(n *uint16) {
+	if b := codec.fixed(2); b == nil {
+		return
+	} else if codec.loading {
+		*n = binary.LittleEndian.Uint16(b)
+	} else {
+		binary.LittleEndian.PutUint16(b, *n)
+	}
+}
+
+func (codec *stateCodec) i64(n *int64) {
+	if b := codec.fixed(8); b == nil {
+		return
+	} else if codec.loading {
+		*n = int64(binary.LittleEndian.Uint64(b))
+	} else {
+		binary.LittleEndian.PutUint64(b, uint64(*n))
+	}
+}
+
+func (codec *stateCodec) int(n *int) {
+	i := int64(*n)
+	codec.i64(&i)
+	*n = int(i)
+}
+
+func (codec *stateCodec) bool(b *bool) {
+	var n byte
+	if *b {
+		n = 1
+	}
+	codec.u8(&n)
+	*b = n != 0
+}
+
+// bytes handles a region whose size is fixed by the hardware or cartridge,
+// a state with a different size doesn't belong to this console
+func (codec *stateCodec) bytes(region []byte) {
+	size := len(region)
+	codec.int(&size)
+	if codec.loading && codec.err == nil && size != len(region) {
+		codec.err = ErrStateMismatch
+		return
+	}
+	if b := codec.fixed(len(region)); b == nil {
+		return
+	} else if codec.loading {
+		copy(region, b)
+	} else {
+		copy(b, region)
+	}
+}
+
+func (codec *stateCodec) string(s *string) {
+	size := len(*s)
+	codec.int(&size)
+	if codec.loading && (size < 0 || size > len(codec.data)) {
+		codec.err = ErrInvalidState
+	}
+	if b := codec.fixed(size); b == nil {
+		return
+	} else if codec.loading {
+		*s = string(b)
+	} else {
+		copy(b, *s)
+	}
+}
+
+// stateSection is a tagged chunk of the save state owned by one component
+type stateSection struct {
+	tag   string
+	state func(codec *stateCodec)
+}
+
+// stateSections lists what goes in a save state, a new component gets a
+// new tag so states written before it existed still load
+func (console *Console) stateSections() []stateSection {
+	memory := console.memory
+	sections := []stateSection{
+		{"CPU ", console.cpu.state},
+		{"MEM ", memory.state},
+		{"INT ", memory.interrupts.state},
+		{"TIMR", memory.timer.state},
+		{"JOYP", memory.joypad.state},
+		{"SERL", memory.serial.state},
+		{"PPU ", console.ppu.state},
+		{"APU ", memory.apu.state},
+		{"MBC ", memory.mbc.state},
+	}
+	if memory.rtc != nil {
+		sections = append(sections, stateSection{"RTC ", memory.rtc.state})
+	}
+	return sections
+}
+
+// stateHeader identifies the rom and the emulator that wrote a state
+type stateHeader struct {
+	magic          string
+	format         uint16
+	headerChecksum byte
+	globalChecksum uint16
+	title          string
+	version        string
+}
+
+func (header *stateHeader) state(codec *stateCodec) {
+	magic := codec.fixed(len(stateMagic))
+	if magic != nil && codec.loading {
+		header.magic = string(magic)
+	} else if magic != nil {
+		copy(magic, header.magic)
+	}
+	codec.u16(&header.format)
+	codec.u8(&header.headerChecksum)
+	codec.u16(&header.globalChecksum)
+	codec.string(&header.title)
+	codec.string(&header.version)
+}
+
+func (console *Console) stateHeader() stateHeader {
+	return stateHeader{
+		magic:          stateMagic,
+		format:         stateFormat,
+		headerChecksum: console.cartridge.Header.HeaderChecksum,
+		globalChecksum: console.cartridge.Header.GlobalChecksum,
+		title:          console.cartridge.Header.Title,
+		version:        Version,
+	}
+}
+
+// SaveState writes a snapshot of the whole console to w
+func (console *Console) SaveState(w io.Writer) error {
+	_, err := w.Write(console.encodeState())
+	return err
+}
+
+func (console *Console) encodeState() []byte {
+	codec := &stateCodec{}
+	header := console.stateHeader()
+	header.state(codec)
+
+	for _, section := range console.stateSections() {
+		sectionCodec := &stateCodec{}
+		section.state(sectionCodec)
+
+		codec.data = append(codec.data, section.tag...)
+		binary.LittleEndian.PutUint32(codec.fixed(4), uint32(len(sectionCodec.data)))
+		codec.data = append(codec.data, sectionCodec.data...)
+	}
+	return codec.data
+}
+
+// LoadState restores a snapshot written by SaveState, states from another
+// rom or a newer format are rejected and leave the console untouched
+func (console *Console) LoadState(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	codec := &stateCodec{loading: true, data: data}
+	var header stateHeader
+	header.state(codec)
+	if codec.err != nil || header.magic != stateMagic {
+		return ErrInvalidState
+	}
+	if header.format > stateFormat {
+		return fmt.Errorf("%w: format %d, this is %s", ErrStateVersion, header.format, Version)
+	}
+	expected := console.stateHeader()
+	if header.headerChecksum != expected.headerChecksum || header.globalChecksum != expected.globalChecksum {
+		return fmt.Errorf("%w: state is for %q", ErrStateMismatch, header.title)
+	}
+
+	sections := make(map[string][]byte)
+	for len(codec.data) > 0 {
+		tag := codec.fixed(4)
+		size := codec.fixed(4)
+		if size == nil {
+			return ErrInvalidState
+		}
+		payload := codec.fixed(int(binary.LittleEndian.Uint32(size)))
+		if payload == nil {
+			return ErrInvalidState
+		}
+		sections[string(tag)] = payload
+	}
+
+	backup := console.encodeState()
+	if err := console.applyState(sections); err != nil {
+		console.restoreState(backup)
+		return err
+	}
+	return nil
+}
+
+// applyState loads the sections this version knows about, anything missing
+// from an older state keeps its current value
+func (console *Console) applyState(sections map[string][]byte) error {
+	for _, section := range console.stateSections() {
+		data, ok := sections[section.tag]
+		if !ok {
+			continue
+		}
+
+		codec := &stateCodec{loading: true, data: data}
+		section.state(codec)
+		if codec.err != nil {
+			return fmt.Errorf("%w: %s section: %v", ErrInvalidState, section.tag, codec.err)
+		}
+	}
+
+	// cartridge ram changed under the save file
+	console.memory.eramDirty = true
+	return nil
+}
+
+// restoreState puts back a state encoded by this same console
+func (console *Console) restoreState(data []byte) {
+	codec := &stateCodec{loading: true, data: data}
+	var header stateHeader
+	header.state(codec)
+
+	sections := make(map[string][]byte)
+	for len(codec.data) > 0 {
+		tag := codec.fixed(4)
+		size := binary.LittleEndian.Uint32(codec.fixed(4))
+		sections[string(tag)] = codec.fixed(int(size))
+	}
+	console.applyState(sections)
+}
+
+func (cpu *cpu) state(codec *stateCodec) {
+	for _, r := range []*byte{cpu.a, cpu.b, cpu.c, cpu.d, cpu.e, cpu.h, cpu.l} {
+		codec.u8(r)
+	}
+	f := flagsToByte(*cpu.flags)
+	codec.u8(&f)
+	*cpu.flags = byteToFlags(f)
+	codec.u16(&cpu.sp)
+	codec.u16(&cpu.pc)
+	codec.bool(&cpu.ime)
+	codec.bool(&cpu.imePending)
+	codec.bool(&cpu.halted)
+	codec.bool(&cpu.haltBug)
+	codec.bool(&cpu.locked)
+}
+
+func (memory *memory) state(codec *stateCodec) {
+	bootROM := memory.bootROM != nil
+	codec.bool(&bootROM)
+	if bootROM {
+		if memory.bootROM == nil {
+			memory.bootROM = make([]byte, bootROMSize)
+		}
+		codec.bytes(memory.bootROM)
+	} else if codec.loading {
+		memory.bootROM = nil
+	}
+
+	for _, region := range []*[]byte{memory.vram, memory.eram, memory.wram0, memory.wram1, memory.oam, memory.unusable, memory.io, memory.hram} {
+		codec.bytes(*region)
+	}
+}
+
+func (interrupts *interrupts) state(codec *stateCodec) {
+	codec.u8(&interrupts.enable)
+	codec.u8(&interrupts.flags)
+}
+
+func (timer *timer) state(codec *stateCodec) {
+	codec.u16(&timer.divider)
+	codec.u8(&timer.tima)
+	codec.u8(&timer.tma)
+	codec.u8(&timer.tac)
+	codec.int(&timer.overflow)
+	codec.int(&timer.reloaded)
+}
+
+// state leaves out the held buttons, they come from the frontend
+func (joypad *joypad) state(codec *stateCodec) {
+	codec.u8(&joypad.selection)
+}
+
+func (serial *serial) state(codec *stateCodec) {
+	codec.u8(&serial.sb)
+	codec.u8(&serial.sc)
+	codec.int(&serial.clock)
+	codec.int(&serial.bits)
+}
+
+func (ppu *ppu) state(codec *stateCodec) {
+	codec.int(&ppu.modeClock)
+	codec.int(&ppu.windowLine)
+	codec.bool(&ppu.statLine)
+}
+
+func (apu *apu) state(codec *stateCodec) {
+	codec.bool(&apu.power)
+	codec.bytes(apu.registers[:])
+	codec.int(&apu.frameClock)
+	codec.int(&apu.frameStep)
+	codec.int(&apu.sampleClock)
+	apu.square1.state(codec)
+	apu.square2.state(codec)
+	apu.wave.state(codec)
+	apu.noise.state(codec)
+}
+
+func (square *square) state(codec *stateCodec) {
+	codec.bool(&square.enabled)
+	codec.bool(&square.dacEnabled)
+	codec.u8(&square.duty)
+	codec.int(&square.dutyStep)
+	codec.u16(&square.frequency)
+	codec.int(&square.timer)
+	square.length.state(codec)
+	square.envelope.state(codec)
+	codec.u8(&square.sweepPeriod)
+	codec.bool(&square.sweepNegate)
+	codec.u8(&square.sweepShift)
+	codec.u8(&square.sweepTimer)
+	codec.bool(&square.sweepEnabled)
+	codec.u16(&square.shadowFreq)
+}
+
+func (wave *wave) state(codec *stateCodec) {
+	codec.bool(&wave.enabled)
+	codec.bool(&wave.dacEnabled)
+	codec.int(&wave.position)
+	codec.u8(&wave.volumeCode)
+	codec.u16(&wave.frequency)
+	codec.int(&wave.timer)
+	wave.length.state(codec)
+}
+
+func (noise *noise) state(codec *stateCodec) {
+	codec.bool(&noise.enabled)
+	codec.bool(&noise.dacEnabled)
+	codec.u8(&noise.shift)
+	codec.bool(&noise.narrow)
+	codec.u8(&noise.divisor)
+	codec.u16(&noise.lfsr)
+	codec.int(&noise.timer)
+	noise.length.state(codec)
+	noise.envelope.state(codec)
+}
+
+func (length *lengthCounter) state(codec *stateCodec) {
+	codec.int(&length.counter)
+	codec.bool(&length.enabled)
+}
+
+func (envelope *envelope) state(codec *stateCodec) {
+	codec.u8(&envelope.initial)
+	codec.bool(&envelope.increase)
+	codec.u8(&envelope.period)
+	codec.u8(&envelope.volume)
+	codec.u8(&envelope.timer)
+}
+
+// state leaves out the clock mode, that's a frontend setting
+func (rtc *rtc) state(codec *stateCodec) {
+	codec.u8(&rtc.seconds)
+	codec.u8(&rtc.minutes)
+	codec.u8(&rtc.hours)
+	codec.u16(&rtc.days)
+	codec.bool(&rtc.halt)
+	codec.bool(&rtc.carry)
+	for i := range rtc.latched {
+		codec.u8(&rtc.latched[i])
+	}
+	codec.u8(&rtc.latchLast)
+	codec.int(&rtc.cycles)
+	codec.i64(&rtc.lastTime)
+}
+
+// the cartridge ram itself is saved with the rest of memory, the
+// controllers only add their bank registers
+
+func (mbc *romOnly) state(codec *stateCodec) {}
+
+func (mbc *mbc1) state(codec *stateCodec) {
+	codec.bool(&mbc.ramEnabled)
+	codec.u8(&mbc.bank1)
+	codec.u8(&mbc.bank2)
+	codec.u8(&mbc.mode)
+}
+
+func (mbc *mbc2) state(codec *stateCodec) {
+	codec.bool(&mbc.ramEnabled)
+	codec.u8(&mbc.romBank)
+}
+
+func (mbc *mbc3) state(codec *stateCodec) {
+	codec.bool(&mbc.ramEnabled)
+	codec.u8(&mbc.romBank)
+	codec.u8(&mbc.ramBank)
+}
+
+func (mbc *mbc5) state(codec *stateCodec) {
+	codec.bool(&mbc.ramEnabled)
+	codec.u16(&mbc.romBank)
+	codec.u8(&mbc.ramBank)
+}
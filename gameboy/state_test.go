@@ -0,0 +1,105 @@
+package gameboy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// savedState runs a few frames with a button held and returns the state
+func savedState(t *testing.T, console *Console) []byte {
+	console.PressButton(ButtonA)
+	for i := 0; i < 5; i++ {
+		runFrame(t, console)
+	}
+
+	var state bytes.Buffer
+	if err := console.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	return state.Bytes()
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	state := savedState(t, benchmarkConsole(t))
+
+	console := benchmarkConsole(t)
+	if err := console.LoadState(bytes.NewReader(state)); err != nil {
+		t.Fatal(err)
+	}
+	var again bytes.Buffer
+	if err := console.SaveState(&again); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Bytes(), state) {
+		t.Error("saving a loaded state gave different bytes")
+	}
+}
+
+func TestStateReplayIsDeterministic(t *testing.T) {
+	original := benchmarkConsole(t)
+	state := savedState(t, original)
+
+	replay := benchmarkConsole(t)
+	if err := replay.LoadState(bytes.NewReader(state)); err != nil {
+		t.Fatal(err)
+	}
+
+	for frame := 0; frame < 10; frame++ {
+		for _, console := range []*Console{original, replay} {
+			if frame == 3 {
+				console.ReleaseButton(ButtonA)
+			}
+			runFrame(t, console)
+		}
+		if !bytes.Equal(original.encodeState(), replay.encodeState()) {
+			t.Fatalf("replay from the state diverged on frame %d", frame)
+		}
+		if !bytes.Equal(original.GetScreenData(), replay.GetScreenData()) {
+			t.Fatalf("replay from the state drew a different frame %d", frame)
+		}
+	}
+}
+
+func TestLoadStateRejectsBadInput(t *testing.T) {
+	state := savedState(t, benchmarkConsole(t))
+
+	// the header is the magic, a 16-bit format, the header checksum and the
+	// 16-bit global checksum
+	newer := append([]byte{}, state...)
+	binary.LittleEndian.PutUint16(newer[4:], stateFormat+1)
+	checksum := append([]byte{}, state...)
+	checksum[6]++
+	magic := append([]byte{}, state...)
+	copy(magic, "NOPE")
+
+	tests := []struct {
+		name  string
+		state []byte
+		err   error
+	}{
+		{"empty", nil, ErrInvalidState},
+		{"cut in the magic", state[:3], ErrInvalidState},
+		{"cut in the header", state[:10], ErrInvalidState},
+		{"cut in a section", state[:len(state)/2], ErrInvalidState},
+		{"last byte missing", state[:len(state)-1], ErrInvalidState},
+		{"wrong magic", magic, ErrInvalidState},
+		{"wrong checksum", checksum, ErrStateMismatch},
+		{"newer format", newer, ErrStateVersion},
+	}
+
+	for _, test := range tests {
+		console := benchmarkConsole(t)
+		runFrame(t, console)
+		before := console.encodeState()
+
+		err := console.LoadState(bytes.NewReader(test.state))
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+		if !bytes.Equal(console.encodeState(), before) {
+			t.Errorf("%s: rejected state changed the console", test.name)
+		}
+	}
+}
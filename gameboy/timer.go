@@ -0,0 +1,107 @@
+package gameboy
+
+const (
+	divAddr  = 0xFF04
+	timaAddr = 0xFF05
+	tmaAddr  = 0xFF06
+	tacAddr  = 0xFF07
+
+	timerEnableBit = 2
+	// tima is reloaded from tma one m-cycle after it overflows
+	reloadDelay = 4
+)
+
+// timerBits maps the tac clock select to the divider bit whose falling edge increments tima
+var timerBits = [4]uint{9, 3, 5, 7}
+
+// timer models DIV/TIMA/TMA/TAC on top of the 16-bit internal divider
+type timer struct {
+	interrupts *interrupts
+	divider    uint16
+	tima       byte
+	tma        byte
+	tac        byte
+	overflow   int
+	reloaded   int
+}
+
+func initializeTimer(interrupts *interrupts) *timer {
+	return &timer{
+		interrupts: interrupts,
+		divider:    0xABCC,
+		tac:        0xF8,
+	}
+}
+
+// tick advances the timer one t-cycle at a time
+func (timer *timer) tick(cycles int) {
+	for i := 0; i < cycles; i++ {
+		if timer.reloaded > 0 {
+			timer.reloaded--
+		}
+		if timer.overflow > 0 {
+			timer.overflow--
+			if timer.overflow == 0 {
+				timer.tima = timer.tma
+				timer.interrupts.request(timerInterrupt)
+				timer.reloaded = reloadDelay
+			}
+		}
+
+		signal := timer.signal()
+		timer.divider++
+		timer.detectFallingEdge(signal)
+	}
+}
+
+// signal is the selected divider bit and-ed with the timer enable bit
+func (timer *timer) signal() bool {
+	bit := timerBits[timer.tac&0x03]
+	return getBit(timer.tac, timerEnableBit) == 1 && (timer.divider>>bit)&1 == 1
+}
+
+func (timer *timer) detectFallingEdge(oldSignal bool) {
+	if oldSignal && !timer.signal() {
+		timer.tima++
+		if timer.tima == 0 {
+			timer.overflow = reloadDelay
+		}
+	}
+}
+
+func (timer *timer) read(address uint16) byte {
+	switch address {
+	case divAddr:
+		return byte(timer.divider >> 8)
+	case timaAddr:
+		return timer.tima
+	case tmaAddr:
+		return timer.tma
+	default:
+		return timer.tac | 0xF8
+	}
+}
+
+func (timer *timer) write(address uint16, n byte) {
+	switch address {
+	case divAddr:
+		signal := timer.signal()
+		timer.divider = 0
+		timer.detectFallingEdge(signal)
+	case timaAddr:
+		// writes during the reload cycle lose to tma, writes before it cancel the reload
+		if timer.reloaded == 0 {
+			timer.tima = n
+			timer.overflow = 0
+		}
+	case tmaAddr:
+		timer.tma = n
+		if timer.reloaded > 0 {
+			timer.tima = n
+		}
+	default:
+		signal := timer.signal()
+		timer.tac = n | 0xF8
+		timer.detectFallingEdge(signal)
+	}
+}
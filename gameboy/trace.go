@@ -0,0 +1,92 @@
+package gameboy
+
+import (
+	"fmt"
+	"io"
+)
+
+// TraceEvent is the cpu state right before an instruction ran, plus how many cycles it took
+type TraceEvent struct {
+	PC     uint16
+	Opcode byte
+	// PCMem holds the instruction bytes starting at PC
+	PCMem  [4]byte
+	A, F   byte
+	B, C   byte
+	D, E   byte
+	H, L   byte
+	SP     uint16
+	IME    bool
+	Cycles int
+}
+
+// Tracer receives an event for every instruction the cpu executes
+type Tracer interface {
+	Trace(event TraceEvent)
+}
+
+// SetTracer starts sending instruction events to tracer, nil turns tracing off
+func (console *Console) SetTracer(tracer Tracer) {
+	console.cpu.tracer = tracer
+}
+
+// doctorLY is what LY always reads as in the emulator the gameboy-doctor
+// reference logs were made with
+const doctorLY = 0x90
+
+// SetDoctorMode makes the cpu always read LY as 0x90 so logs line up with
+// gameboy-doctor's, the ppu itself keeps counting lines as normal
+func (console *Console) SetDoctorMode(enabled bool) {
+	console.memory.doctorLY = enabled
+}
+
+// traceEvent snapshots the registers before the instruction at pc executes
+func (cpu *cpu) traceEvent(memory *memory) TraceEvent {
+	event := TraceEvent{
+		PC:  cpu.pc,
+		A:   *cpu.a,
+		F:   flagsToByte(*cpu.flags),
+		B:   *cpu.b,
+		C:   *cpu.c,
+		D:   *cpu.d,
+		E:   *cpu.e,
+		H:   *cpu.h,
+		L:   *cpu.l,
+		SP:  cpu.sp,
+		IME: cpu.ime,
+	}
+	for i := range event.PCMem {
+		event.PCMem[i] = memory.read(cpu.pc + uint16(i))
+	}
+	event.Opcode = event.PCMem[0]
+	return event
+}
+
+// DoctorTracer writes one line per instruction in the gameboy-doctor log
+// format, wrap w in a bufio.Writer since this is called a lot
+type DoctorTracer struct {
+	w   io.Writer
+	err error
+}
+
+// NewDoctorTracer returns a tracer writing gameboy-doctor logs to w
+func NewDoctorTracer(w io.Writer) *DoctorTracer {
+	return &DoctorTracer{w: w}
+}
+
+// Trace writes the event, after the first write error it stops writing
+func (tracer *DoctorTracer) Trace(event TraceEvent) {
+	if tracer.err != nil {
+		return
+	}
+
+	_, tracer.err = fmt.Fprintf(tracer.w,
+		"A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X\n",
+		event.A, event.F, event.B, event.C, event.D, event.E, event.H, event.L, event.SP, event.PC,
+		event.PCMem[0], event.PCMem[1], event.PCMem[2], event.PCMem[3])
+}
+
+// Err returns the first error hit while writing
+func (tracer *DoctorTracer) Err() error {
+	return tracer.err
+}
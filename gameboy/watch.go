@@ -0,0 +1,99 @@
+package gameboy
+
+import "errors"
+
+// ErrNoHook is returned when adding a watchpoint without a Hook to call
+var ErrNoHook = errors.New("watchpoint has no hook")
+
+// Access is a kind of memory access, they can be or'd together
+type Access int
+
+const (
+	// AccessRead is any cpu read, including fetching instructions
+	AccessRead Access = 1 << iota
+	// AccessWrite is a cpu write
+	AccessWrite
+	// AccessExecute is the cpu starting an instruction at the address
+	AccessExecute
+)
+
+// WatchEvent describes the access that set off a watchpoint
+type WatchEvent struct {
+	Access  Access
+	Address uint16
+	// Old is the value before a write, for reads and executes it's the
+	// same as New
+	Old, New byte
+	// PC is where the instruction making the access starts
+	PC uint16
+}
+
+// Watchpoint calls Hook whenever the cpu accesses Address in one of the
+// ways in Access. Writes are reported before they land
+type Watchpoint struct {
+	Address uint16
+	// End widens the watchpoint to Address through End
+	End    uint16
+	Access Access
+	// Match only reports accesses where the new value is Value
+	Match bool
+	Value byte
+	Hook  func(event WatchEvent)
+}
+
+// watchEntry is a registered Watchpoint with the id to remove it by
+type watchEntry struct {
+	Watchpoint
+	id int
+}
+
+// AddWatchpoint registers watchpoint and returns an id for RemoveWatchpoint
+func (console *Console) AddWatchpoint(watchpoint Watchpoint) (int, error) {
+	if watchpoint.Hook == nil {
+		return 0, ErrNoHook
+	}
+
+	bus := console.cpu.bus
+	if watchpoint.End < watchpoint.Address {
+		watchpoint.End = watchpoint.Address
+	}
+	bus.watchID++
+	bus.watches = append(bus.watches, watchEntry{Watchpoint: watchpoint, id: bus.watchID})
+	return bus.watchID, nil
+}
+
+// RemoveWatchpoint unregisters the watchpoint with id, once none are left
+// memory accesses are back to full speed
+func (console *Console) RemoveWatchpoint(id int) {
+	bus := console.cpu.bus
+	for i, watchpoint := range bus.watches {
+		if watchpoint.id == id {
+			// copy so a hook removing itself doesn't disturb the loop calling it
+			bus.watches = append(bus.watches[:i:i], bus.watches[i+1:]...)
+			break
+		}
+	}
+	if len(bus.watches) == 0 {
+		bus.watches = nil
+	}
+}
+
+// watch calls the hooks of every watchpoint matching the access, callers
+// check bus.watches first so nothing is paid when there are none
+func (bus *bus) watch(access Access, address uint16, old byte, n byte) {
+	for _, watchpoint := range bus.watches {
+		if watchpoint.Access&access == 0 || address < watchpoint.Address || address > watchpoint.End {
+			continue
+		}
+		if watchpoint.Match && n != watchpoint.Value {
+			continue
+		}
+		watchpoint.Hook(WatchEvent{
+			Access:  access,
+			Address: address,
+			Old:     old,
+			New:     n,
+			PC:      bus.pc,
+		})
+	}
+}
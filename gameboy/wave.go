@@ -0,0 +1,48 @@
+package gameboy
+
+// waveShifts maps the NR32 volume code to how far each sample is shifted right
+var waveShifts = [4]byte{4, 0, 1, 2}
+
+// wave plays back the 32 4-bit samples in wave ram
+type wave struct {
+	enabled    bool
+	dacEnabled bool
+	ram        []byte
+	position   int
+	volumeCode byte
+	frequency  uint16
+	timer      int
+	length     lengthCounter
+}
+
+func (wave *wave) tick(cycles int) {
+	wave.timer -= cycles
+	for wave.timer <= 0 {
+		wave.timer += int(2048-wave.frequency) * 2
+		wave.position = (wave.position + 1) % 32
+	}
+}
+
+func (wave *wave) output() byte {
+	if !wave.enabled {
+		return 0
+	}
+
+	sample := wave.ram[wave.position/2]
+	if wave.position%2 == 0 {
+		sample >>= 4
+	}
+	return (sample & 0x0F) >> waveShifts[wave.volumeCode]
+}
+
+func (wave *wave) writeControl(n byte) {
+	wave.frequency = uint16(n&0x07)<<8 | wave.frequency&0xFF
+	wave.length.enabled = getBit(n, lengthEnabled) == 1
+	if getBit(n, triggerBit) == 1 {
+		wave.enabled = wave.dacEnabled
+		wave.length.max = 256
+		wave.length.trigger()
+		wave.timer = int(2048-wave.frequency) * 2
+		wave.position = 0
+	}
+}
@@ -1,5 +1,5 @@
 module github.com/alaughlin/go-boi
 
-go 1.15
+go 1.16
 
 require github.com/hajimehoshi/ebiten/v2 v2.0.6
@@ -1,46 +1,201 @@
 package main
 
 import (
-	"image/color"
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
 
+	"github.com/alaughlin/go-boi/debugger"
 	"github.com/alaughlin/go-boi/gameboy"
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/audio"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 )
 
 const (
-	width           = 160
-	height          = 144
-	scaleFactor     = 4
+	width           = gameboy.ScreenWidth
+	height          = gameboy.ScreenHeight
 	cyclesPerUpdate = 69905
+	// rewindKey is held to play the rewind history backwards
+	rewindKey = ebiten.KeyR
 )
 
 var (
-	colors map[int]color.RGBA = map[int]color.RGBA{0x3: {255, 255, 255, 255}, 0x2: {170, 170, 170, 255}, 0x1: {85, 85, 85, 255}, 0x0: {0, 0, 0, 255}}
+	keys map[ebiten.Key]gameboy.Button = map[ebiten.Key]gameboy.Button{
+		ebiten.KeyRight:     gameboy.ButtonRight,
+		ebiten.KeyLeft:      gameboy.ButtonLeft,
+		ebiten.KeyUp:        gameboy.ButtonUp,
+		ebiten.KeyDown:      gameboy.ButtonDown,
+		ebiten.KeyX:         gameboy.ButtonA,
+		ebiten.KeyZ:         gameboy.ButtonB,
+		ebiten.KeyBackspace: gameboy.ButtonSelect,
+		ebiten.KeyEnter:     gameboy.ButtonStart,
+	}
+	// slotKeys load the numbered save state slots, with shift held they save
+	slotKeys []ebiten.Key = []ebiten.Key{
+		ebiten.KeyF1, ebiten.KeyF2, ebiten.KeyF3,
+		ebiten.KeyF4, ebiten.KeyF5, ebiten.KeyF6,
+		ebiten.KeyF7, ebiten.KeyF8, ebiten.KeyF9,
+	}
+	palettes map[string]gameboy.Palette = map[string]gameboy.Palette{
+		"grey":   gameboy.GreyPalette,
+		"green":  gameboy.GreenPalette,
+		"pocket": gameboy.PocketPalette,
+	}
+	rtcModes map[string]gameboy.RTCMode = map[string]gameboy.RTCMode{
+		"wall":     gameboy.RTCWallClock,
+		"emulated": gameboy.RTCEmulated,
+	}
 )
 
+// options holds everything set from the command line
+type options struct {
+	romPath    string
+	scale      int
+	fullscreen bool
+	bootROM    string
+	saveDir    string
+	audio      bool
+	palette    string
+	rtc        string
+	speed      float64
+	headless   bool
+	trace      string
+	serial     string
+	host       string
+	join       string
+	rewind     int
+	rewindStep int
+	debug      bool
+}
+
 // App holds the gameboy
 type App struct {
-	Gameboy *gameboy.Console
+	Gameboy   *gameboy.Console
+	frame     *ebiten.Image
+	audio     *audioStream
+	cycles    int
+	statePath string
+	rewinder  *gameboy.Rewinder
+	debugger  *debugger.Debugger
+	// rewindClock counts frames while rewindKey is held
+	rewindClock int
 }
 
 // Update executes 60 times/second
 func (g *App) Update() error {
-	cycles := 0
-	for cycles < cyclesPerUpdate {
-		cycles += g.Gameboy.Tick()
+	var buttons gameboy.Button
+	for key, button := range keys {
+		if ebiten.IsKeyPressed(key) {
+			buttons |= button
+		}
+	}
+	g.Gameboy.SetButtons(buttons)
+	g.handleStateKeys()
+
+	if g.rewinder != nil && ebiten.IsKeyPressed(rewindKey) {
+		return g.rewind()
+	}
+	g.rewindClock = 0
+
+	if g.debugger != nil {
+		n, err := g.debugger.Update(g.cycles)
+		if err != nil || n == 0 {
+			return err
+		}
+	} else if err := g.run(g.cycles); err != nil {
+		return err
+	}
+	if g.rewinder != nil {
+		g.rewinder.Frame()
+	}
+	samples := g.Gameboy.AudioSamples()
+	if g.audio != nil {
+		g.audio.push(samples)
 	}
 	return nil
 }
 
+func (g *App) run(cycles int) error {
+	for cycles > 0 {
+		n, err := g.Gameboy.Tick()
+		if err != nil {
+			return err
+		}
+		cycles -= n
+	}
+	return nil
+}
+
+// rewind goes back one snapshot every rewind interval so the history plays
+// backwards at normal speed, each step runs a silent frame to redraw the
+// screen and then restores the snapshot again, so letting go of the key
+// resumes exactly where the history left off
+func (g *App) rewind() error {
+	step := g.rewindClock%g.rewinder.Interval() == 0
+	g.rewindClock++
+	if !step {
+		return nil
+	}
+
+	ok, err := g.rewinder.Rewind()
+	if err != nil {
+		log.Printf("cannot rewind: %v", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	var state bytes.Buffer
+	if err := g.Gameboy.SaveState(&state); err != nil {
+		return err
+	}
+	if err := g.run(cyclesPerUpdate); err != nil {
+		return err
+	}
+	g.Gameboy.AudioSamples()
+	return g.Gameboy.LoadState(&state)
+}
+
+// handleStateKeys saves or loads a slot when its key goes down, a bad
+// state is only logged so a typo on the keyboard can't end the game
+func (g *App) handleStateKeys() {
+	shift := ebiten.IsKeyPressed(ebiten.KeyShift)
+	for i, key := range slotKeys {
+		if !inpututil.IsKeyJustPressed(key) {
+			continue
+		}
+		slot := i + 1
+		path := fmt.Sprintf("%s.ss%d", g.statePath, slot)
+		if shift {
+			if err := saveState(g.Gameboy, path); err != nil {
+				log.Printf("cannot save state %d: %v", slot, err)
+			} else {
+				log.Printf("saved state %d", slot)
+			}
+		} else {
+			if err := loadState(g.Gameboy, path); err != nil {
+				log.Printf("cannot load state %d: %v", slot, err)
+			} else {
+				log.Printf("loaded state %d", slot)
+			}
+		}
+	}
+}
+
 // Draw takes the display data and draws it to the screen
 func (g *App) Draw(screen *ebiten.Image) {
-	screen.Fill(color.White)
-	square := ebiten.NewImage(100, 100)
-	square.Fill(colors[0x2])
-	options := &ebiten.DrawImageOptions{}
-	options.GeoM.Translate(0, 0)
-	screen.DrawImage(square, options)
+	g.frame.ReplacePixels(g.Gameboy.GetScreenData())
+	screen.DrawImage(g.frame, nil)
 }
 
 // Layout defines the internal resolution which is later scaled
@@ -48,13 +203,318 @@ func (g *App) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight
 	return width, height
 }
 
+func parseFlags() options {
+	opts := options{}
+	flag.IntVar(&opts.scale, "scale", 4, "window scale factor")
+	flag.BoolVar(&opts.fullscreen, "fullscreen", false, "start in fullscreen")
+	flag.StringVar(&opts.bootROM, "boot", "", "path to a 256 byte dmg boot rom to run first")
+	flag.StringVar(&opts.saveDir, "savedir", "", "directory for .sav files (default: next to the rom)")
+	flag.BoolVar(&opts.audio, "audio", true, "play sound")
+	flag.StringVar(&opts.palette, "palette", "grey", "screen colors: grey, green or pocket")
+	flag.StringVar(&opts.rtc, "rtc", "wall", "cartridge clock: wall follows the host's time, emulated follows emulated time")
+	flag.Float64Var(&opts.speed, "speed", 1, "emulation speed multiplier")
+	flag.BoolVar(&opts.headless, "headless", false, "run without a window as fast as possible until interrupted")
+	flag.StringVar(&opts.serial, "serial", "none", "link port device: none, stdout or loopback")
+	flag.StringVar(&opts.host, "host", "", "host a link cable session on this address, e.g. :5555")
+	flag.StringVar(&opts.join, "join", "", "join a link cable session at this address, e.g. 192.168.1.2:5555")
+	flag.IntVar(&opts.rewind, "rewind", 64, "megabytes of rewind history to keep, 0 turns rewinding off")
+	flag.IntVar(&opts.rewindStep, "rewind-interval", 4, "frames between rewind snapshots")
+	flag.BoolVar(&opts.debug, "debug", false, "start paused in a debugger reading commands from the terminal")
+	flag.StringVar(&opts.trace, "trace", "", "write a gameboy-doctor log of every instruction to this file, LY reads as 0x90 while tracing")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] rom.gb\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.romPath = flag.Arg(0)
+
+	return opts
+}
+
+// validate catches bad flag values before the console is created
+func (opts options) validate() error {
+	if opts.scale < 1 {
+		return fmt.Errorf("scale must be at least 1, got %d", opts.scale)
+	}
+	if opts.speed <= 0 {
+		return fmt.Errorf("speed must be positive, got %v", opts.speed)
+	}
+	if opts.rewind < 0 {
+		return fmt.Errorf("rewind must not be negative, got %d", opts.rewind)
+	}
+	if opts.rewindStep < 1 {
+		return fmt.Errorf("rewind-interval must be at least 1, got %d", opts.rewindStep)
+	}
+	if _, ok := palettes[opts.palette]; !ok {
+		return fmt.Errorf("unknown palette %q, choose grey, green or pocket", opts.palette)
+	}
+	if _, ok := rtcModes[opts.rtc]; !ok {
+		return fmt.Errorf("unknown rtc mode %q, choose wall or emulated", opts.rtc)
+	}
+	if opts.serial != "none" && opts.serial != "stdout" && opts.serial != "loopback" {
+		return fmt.Errorf("unknown serial device %q, choose none, stdout or loopback", opts.serial)
+	}
+	if opts.host != "" && opts.join != "" {
+		return fmt.Errorf("-host and -join can't be used together")
+	}
+	if (opts.host != "" || opts.join != "") && opts.serial != "none" {
+		return fmt.Errorf("-serial can't be used with a link cable session")
+	}
+	return nil
+}
+
+func newConsole(opts options) (*gameboy.Console, error) {
+	data, err := ioutil.ReadFile(opts.romPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", opts.romPath, err)
+	}
+	console, err := gameboy.InitializeConsoleFromBytes(data, width, height)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load %s: %v", opts.romPath, err)
+	}
+	console.SetPalette(palettes[opts.palette])
+	console.SetRTCMode(rtcModes[opts.rtc])
+
+	switch opts.serial {
+	case "stdout":
+		console.SetSerialDevice(gameboy.NewSerialWriter(os.Stdout))
+	case "loopback":
+		console.SetSerialDevice(gameboy.SerialLoopback{})
+	}
+
+	if opts.bootROM != "" {
+		data, err := ioutil.ReadFile(opts.bootROM)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read boot rom: %v", err)
+		}
+		if err := console.LoadBootROM(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := console.SetSavePath(statePath(opts) + ".sav"); err != nil {
+		return nil, fmt.Errorf("cannot load save: %v", err)
+	}
+
+	return console, nil
+}
+
+// connectLink hosts or joins a link cable session if one was asked for,
+// hosting blocks until the other player connects
+func connectLink(console *gameboy.Console, opts options) (*gameboy.LinkCable, error) {
+	var cable *gameboy.LinkCable
+	var err error
+	if opts.host != "" {
+		log.Printf("waiting for the other player on %s", opts.host)
+		cable, err = gameboy.HostLink(opts.host)
+	} else if opts.join != "" {
+		cable, err = gameboy.JoinLink(opts.join)
+	} else {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect link cable: %v", err)
+	}
+
+	console.AttachLink(cable)
+	return cable, nil
+}
+
+// startTrace logs every instruction to path, the returned func flushes and
+// closes the file
+func startTrace(console *gameboy.Console, path string) (func() error, error) {
+	if path == "" {
+		return func() error { return nil }, nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create trace: %v", err)
+	}
+	w := bufio.NewWriter(file)
+	tracer := gameboy.NewDoctorTracer(w)
+	console.SetTracer(tracer)
+	console.SetDoctorMode(true)
+
+	return func() error {
+		console.SetTracer(nil)
+		console.SetDoctorMode(false)
+		err := tracer.Err()
+		if flushErr := w.Flush(); err == nil {
+			err = flushErr
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+// statePath is where the .sav file and the save state slots go, minus
+// their extensions, next to the rom or in -savedir
+func statePath(opts options) string {
+	base := strings.TrimSuffix(opts.romPath, filepath.Ext(opts.romPath))
+	if opts.saveDir != "" {
+		return filepath.Join(opts.saveDir, filepath.Base(base))
+	}
+	return base
+}
+
+func saveState(console *gameboy.Console, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := console.SaveState(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func loadState(console *gameboy.Console, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return console.LoadState(file)
+}
+
+// runHeadless ticks the console flat out until ctrl-c, then flushes the save
+func runHeadless(console *gameboy.Console) error {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	for {
+		select {
+		case <-interrupt:
+			return console.Close()
+		default:
+		}
+
+		for cycles := 0; cycles < cyclesPerUpdate; {
+			n, err := console.Tick()
+			if err != nil {
+				console.Close()
+				return err
+			}
+			cycles += n
+		}
+		console.AudioSamples()
+	}
+}
+
+// runDebugger hands a headless console over to the debugger, ctrl-c pauses
+// it rather than exiting
+func runDebugger(console *gameboy.Console, debug *debugger.Debugger) error {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		for range interrupt {
+			debug.Interrupt()
+		}
+	}()
+
+	err := debug.Run()
+	if errors.Is(err, debugger.ErrQuit) {
+		err = nil
+	}
+	if closeErr := console.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 func main() {
+	log.SetFlags(0)
+	log.SetPrefix("go-boi: ")
+
+	opts := parseFlags()
+	if err := opts.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	console, err := newConsole(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cable, err := connectLink(console, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cable != nil {
+		defer cable.Close()
+	}
+
+	stopTrace, err := startTrace(console, opts.trace)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var debug *debugger.Debugger
+	if opts.debug {
+		debug = debugger.New(console, os.Stdin, os.Stdout)
+	}
+
+	if opts.headless {
+		var err error
+		if debug != nil {
+			err = runDebugger(console, debug)
+		} else {
+			err = runHeadless(console)
+		}
+		if traceErr := stopTrace(); err == nil {
+			err = traceErr
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	app := &App{
-		Gameboy: gameboy.InitializeConsole("./roms/blargg/03-op sp,hl.gb", width, height),
+		Gameboy:   console,
+		frame:     ebiten.NewImage(width, height),
+		cycles:    int(cyclesPerUpdate * opts.speed),
+		statePath: statePath(opts),
+		debugger:  debug,
 	}
-	ebiten.SetWindowSize(width*scaleFactor, height*scaleFactor)
+	// the other side of a link cable can't be rewound with us
+	if opts.rewind > 0 && cable == nil {
+		app.rewinder = gameboy.NewRewinder(console, opts.rewindStep, opts.rewind<<20)
+	}
+
+	if opts.audio {
+		app.audio = &audioStream{}
+		player, err := audio.NewPlayer(audio.NewContext(gameboy.SampleRate), app.audio)
+		if err != nil {
+			log.Fatal(err)
+		}
+		player.Play()
+	}
+
+	ebiten.SetWindowSize(width*opts.scale, height*opts.scale)
+	ebiten.SetFullscreen(opts.fullscreen)
 	ebiten.SetWindowTitle("GoBoi")
-	if err := ebiten.RunGame(app); err != nil {
+	err = ebiten.RunGame(app)
+	if errors.Is(err, debugger.ErrQuit) {
+		err = nil
+	}
+	// close even when the game stopped on an error so the save gets written
+	if closeErr := app.Gameboy.Close(); err == nil {
+		err = closeErr
+	}
+	if traceErr := stopTrace(); err == nil {
+		err = traceErr
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,79 @@
+package testrom
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+}
+
+// WriteJUnit writes results as a single JUnit XML test suite, timeouts count
+// as failures and emulator errors as errors
+func WriteJUnit(w io.Writer, suite string, results []Result) error {
+	junit := junitSuite{Name: suite, Tests: len(results)}
+
+	var total time.Duration
+	for _, result := range results {
+		total += result.Duration
+		testCase := junitCase{
+			Name:      result.Name,
+			ClassName: suite,
+			Time:      seconds(result.Duration),
+			SystemOut: result.Serial,
+		}
+
+		switch result.Status {
+		case Fail, Timeout:
+			junit.Failures++
+			testCase.Failure = &junitMessage{Message: result.Message, Type: result.Status.String()}
+		case Error:
+			junit.Errors++
+			testCase.Error = &junitMessage{Message: result.Message, Type: result.Status.String()}
+		}
+		junit.Cases = append(junit.Cases, testCase)
+	}
+	junit.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitSuites{Suites: []junitSuite{junit}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(duration time.Duration) string {
+	return fmt.Sprintf("%.3f", duration.Seconds())
+}
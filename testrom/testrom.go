@@ -0,0 +1,184 @@
+// Package testrom runs test roms headless and decides whether they passed,
+// blargg's roms print "Passed" or "Failed" over the serial port and
+// mooneye's execute LD B,B with fibonacci numbers in the registers on success
+package testrom
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alaughlin/go-boi/gameboy"
+)
+
+const (
+	width  = gameboy.ScreenWidth
+	height = gameboy.ScreenHeight
+
+	// DefaultCycles is two minutes of emulated time, enough for cpu_instrs
+	DefaultCycles = 4194304 * 120
+
+	ldBB = 0x40
+)
+
+// Status is the outcome of a single rom
+type Status int
+
+const (
+	// Pass means the rom reported success
+	Pass Status = iota
+	// Fail means the rom reported failure
+	Fail
+	// Timeout means the rom didn't report anything before the cycle limit
+	Timeout
+	// Error means the rom couldn't be loaded or the emulator stopped
+	Error
+)
+
+func (status Status) String() string {
+	switch status {
+	case Pass:
+		return "pass"
+	case Fail:
+		return "fail"
+	case Timeout:
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+// Result is what happened when a rom was run
+type Result struct {
+	Name     string
+	Status   Status
+	Cycles   int
+	Duration time.Duration
+	// Serial is everything the rom sent over the link port
+	Serial string
+	// Message explains failures, timeouts and errors
+	Message string
+}
+
+// monitor watches the serial port and the instruction stream for a verdict
+type monitor struct {
+	serial  bytes.Buffer
+	done    bool
+	status  Status
+	message string
+}
+
+// Exchange records blargg's output and reads back an empty port
+func (monitor *monitor) Exchange(out byte) byte {
+	monitor.serial.WriteByte(out)
+	if out != '\n' || monitor.done {
+		return 0xFF
+	}
+
+	output := monitor.serial.String()
+	if strings.Contains(output, "Failed") {
+		monitor.finish(Fail, "rom reported failure over serial")
+	} else if strings.Contains(output, "Passed") {
+		monitor.finish(Pass, "")
+	}
+	return 0xFF
+}
+
+// Trace looks for mooneye's LD B,B breakpoint
+func (monitor *monitor) Trace(event gameboy.TraceEvent) {
+	if event.Opcode != ldBB || monitor.done {
+		return
+	}
+
+	if event.B == 3 && event.C == 5 && event.D == 8 && event.E == 13 && event.H == 21 && event.L == 34 {
+		monitor.finish(Pass, "")
+	} else if event.B == 0x42 && event.C == 0x42 && event.D == 0x42 && event.E == 0x42 && event.H == 0x42 && event.L == 0x42 {
+		monitor.finish(Fail, "rom hit the mooneye failure breakpoint")
+	}
+}
+
+func (monitor *monitor) finish(status Status, message string) {
+	monitor.done = true
+	monitor.status = status
+	monitor.message = message
+}
+
+// Run runs the rom at path for at most maxCycles, with the cartridge clock in rtcMode
+func Run(path string, maxCycles int, rtcMode gameboy.RTCMode) Result {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Result{Name: name, Status: Error, Message: err.Error()}
+	}
+	return RunROM(name, data, maxCycles, rtcMode)
+}
+
+// RunROM runs a rom image for at most maxCycles, with the cartridge clock in rtcMode
+func RunROM(name string, data []byte, maxCycles int, rtcMode gameboy.RTCMode) Result {
+	result := Result{Name: name}
+	start := time.Now()
+
+	console, err := gameboy.InitializeConsoleFromBytes(data, width, height)
+	if err != nil {
+		result.Status = Error
+		result.Message = err.Error()
+		return result
+	}
+
+	console.SetRTCMode(rtcMode)
+
+	monitor := &monitor{}
+	console.SetSerialDevice(monitor)
+	console.SetTracer(monitor)
+
+	for result.Cycles < maxCycles && !monitor.done {
+		cycles, err := console.Tick()
+		result.Cycles += cycles
+		if err != nil {
+			monitor.finish(Error, err.Error())
+		}
+	}
+
+	result.Duration = time.Since(start)
+	result.Serial = monitor.serial.String()
+	if !monitor.done {
+		result.Status = Timeout
+		result.Message = "no verdict before the cycle limit"
+		return result
+	}
+	result.Status = monitor.status
+	result.Message = monitor.message
+	return result
+}
+
+// RunDir runs every .gb file under dir, sorted by path
+func RunDir(dir string, maxCycles int, rtcMode gameboy.RTCMode) ([]Result, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.EqualFold(filepath.Ext(path), ".gb") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	results := make([]Result, 0, len(paths))
+	for _, path := range paths {
+		result := Run(path, maxCycles, rtcMode)
+		if rel, err := filepath.Rel(dir, path); err == nil {
+			result.Name = strings.TrimSuffix(filepath.ToSlash(rel), filepath.Ext(rel))
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}